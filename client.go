@@ -0,0 +1,346 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "math/rand"
+    "net"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/gopacket"
+    "github.com/google/gopacket/layers"
+
+    "portknock/config"
+    "portknock/spa"
+)
+
+// defaultClientProfile 返回默认客户端配置路径 ~/.config/portknock/client.yaml
+func defaultClientProfile() string {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return "client.yaml"
+    }
+    return filepath.Join(dir, "portknock", "client.yaml")
+}
+
+// runKnockCommand 实现 `portknock knock <host> --service X` 子命令，返回进程退出码
+func runKnockCommand(args []string) int {
+    fs := flag.NewFlagSet("knock", flag.ContinueOnError)
+    service := fs.String("service", "", "客户端配置中的服务名")
+    profile := fs.String("profile", defaultClientProfile(), "客户端配置文件路径")
+    delay := fs.Duration("delay", 0, "每步之间的间隔（覆盖配置中的 step_delay_ms）")
+    useSPA := fs.Bool("spa", false, "发送 SPA 单包授权而不是端口序列")
+    useSYN := fs.Bool("syn", false, "向放行端口发送携带令牌的 TCP SYN（covert SYN）而不是端口序列")
+    useDNS := fs.Bool("dns", false, "发送查询名携带令牌的 DNS 查询而不是端口序列")
+    wait := fs.Bool("wait", false, "敲门后等待放行端口可连接再退出")
+    waitTimeout := fs.Duration("wait-timeout", 30*time.Second, "--wait 的最长等待时间")
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "用法: portknock knock <host> --service <name> [选项]\n")
+        fs.PrintDefaults()
+    }
+
+    // 允许 host 写在选项之前
+    var host string
+    if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+        host, args = args[0], args[1:]
+    }
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+    if host == "" && fs.NArg() > 0 {
+        host = fs.Arg(0)
+    }
+    if *service == "" {
+        fs.Usage()
+        return 2
+    }
+
+    prof, err := config.LoadClientProfile(*profile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "读取客户端配置失败: %v\n", err)
+        return 1
+    }
+    svc, ok := prof.Service(*service)
+    if !ok {
+        fmt.Fprintf(os.Stderr, "客户端配置中没有服务 %s\n", *service)
+        return 1
+    }
+    if host == "" {
+        host = svc.Host
+    }
+    if host == "" {
+        fmt.Fprintf(os.Stderr, "未指定服务器地址\n")
+        return 2
+    }
+
+    addr, err := net.ResolveIPAddr("ip4", host)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "解析 %s 失败: %v\n", host, err)
+        return 1
+    }
+
+    stepDelay := time.Duration(svc.StepDelayMs) * time.Millisecond
+    if *delay > 0 {
+        stepDelay = *delay
+    }
+    if stepDelay <= 0 {
+        stepDelay = 300 * time.Millisecond
+    }
+
+    switch {
+    case *useSPA:
+        err = sendSPA(addr, svc)
+    case *useSYN:
+        err = sendCovertSYN(addr, svc)
+    case *useDNS:
+        err = sendDNSKnock(addr, svc)
+    default:
+        err = sendSequence(addr, svc, stepDelay)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "敲门失败: %v\n", err)
+        return 1
+    }
+
+    if *wait {
+        // 探测连接本身就是一个新连接，会用掉单次连接授权
+        if svc.SingleConnection {
+            fmt.Fprintf(os.Stderr, "⚠️  服务 %s 为单次连接授权，探测连接会用掉这次授权，已跳过 --wait\n", svc.Name)
+            return 0
+        }
+        if svc.AllowPort == 0 {
+            fmt.Fprintf(os.Stderr, "客户端配置未设置 allow_port，无法等待\n")
+            return 1
+        }
+        if !waitForPort(addr, int(svc.AllowPort), *waitTimeout) {
+            fmt.Fprintf(os.Stderr, "等待 %s:%d 可连接超时\n", addr, svc.AllowPort)
+            return 1
+        }
+        fmt.Printf("✅ %s:%d 已可连接\n", addr, svc.AllowPort)
+    }
+    return 0
+}
+
+// sendSequence 按顺序发送敲门步骤
+func sendSequence(addr *net.IPAddr, svc *config.ClientService, stepDelay time.Duration) error {
+    steps, err := svc.KnockSteps()
+    if err != nil {
+        return err
+    }
+    if len(steps) == 0 {
+        return fmt.Errorf("服务 %s 未配置敲门步骤", svc.Name)
+    }
+
+    // 间隔从上一步发出时算起，不受 TCP 连接超时影响
+    var last time.Time
+    // 每个 ICMP 步骤使用不同的 Echo 序号（与每个 TCP 连接有新的初始序列号一样），
+    // 服务端不会把有意重复的端口当作重传
+    echoSeq := uint16(rand.Intn(65536))
+    for i, step := range steps {
+        if i > 0 {
+            time.Sleep(time.Until(last.Add(clientStepDelay(svc, i+1, stepDelay))))
+        }
+        last = time.Now()
+        if err := sendStep(addr, step, echoSeq+uint16(i)); err != nil {
+            return fmt.Errorf("第 %d 步 %s:%d: %v", i+1, step.Protocol, step.Port, err)
+        }
+        fmt.Printf("🔔 第 %d 步: %s %s:%d\n", i+1, step.Protocol, addr, step.Port)
+    }
+    return nil
+}
+
+// clientStepDelay 返回第 step 步之前的等待时间：默认使用 stepDelay，
+// 不满足服务端要求的间隔时改用窗口中间值（无上限时取下限再加 200ms 余量）
+func clientStepDelay(svc *config.ClientService, step int, stepDelay time.Duration) time.Duration {
+    min, max := svc.StepBounds(step)
+    if stepDelay >= min && (max == 0 || stepDelay <= max) {
+        return stepDelay
+    }
+    if max > 0 {
+        return min + (max-min)/2
+    }
+    return min + 200*time.Millisecond
+}
+
+func sendStep(addr *net.IPAddr, step config.KnockStep, echoSeq uint16) error {
+    target := net.JoinHostPort(addr.String(), strconv.Itoa(step.Port))
+    switch step.Protocol {
+    case "tcp":
+        // 只需要发出 SYN，端口被丢弃或拒绝都是正常的
+        conn, err := net.DialTimeout("tcp4", target, 100*time.Millisecond)
+        if err == nil {
+            conn.Close()
+        }
+        return nil
+    case "udp":
+        conn, err := net.Dial("udp4", target)
+        if err != nil {
+            return err
+        }
+        defer conn.Close()
+        _, err = conn.Write([]byte{0})
+        return err
+    case "icmp":
+        return sendICMPEcho(addr, step.Port, echoSeq)
+    }
+    return fmt.Errorf("未知协议 %s", step.Protocol)
+}
+
+// sendICMPEcho 发送 Identifier 为 id、序号为 seq 的 ICMP Echo 请求（需要 root 或 CAP_NET_RAW）
+func sendICMPEcho(addr *net.IPAddr, id int, seq uint16) error {
+    conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    buf := gopacket.NewSerializeBuffer()
+    icmp := &layers.ICMPv4{
+        TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+        Id:       uint16(id),
+        Seq:      seq,
+    }
+    opts := gopacket.SerializeOptions{ComputeChecksums: true}
+    if err := gopacket.SerializeLayers(buf, opts, icmp, gopacket.Payload("portknock")); err != nil {
+        return err
+    }
+    _, err = conn.WriteTo(buf.Bytes(), addr)
+    return err
+}
+
+// sendSPA 发送一个 SPA 单包授权数据包
+func sendSPA(addr *net.IPAddr, svc *config.ClientService) error {
+    if svc.SPAPort == 0 || svc.Client == "" || svc.Secret == "" {
+        return fmt.Errorf("服务 %s 未配置 spa_port / client / secret", svc.Name)
+    }
+    packet, err := spa.Build(svc.Name, svc.Client, []byte(svc.Secret), time.Now())
+    if err != nil {
+        return err
+    }
+
+    conn, err := net.Dial("udp4", net.JoinHostPort(addr.String(), strconv.Itoa(int(svc.SPAPort))))
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if _, err := conn.Write(packet); err != nil {
+        return err
+    }
+    fmt.Printf("🔔 已发送 SPA 数据包到 %s:%d\n", addr, svc.SPAPort)
+    return nil
+}
+
+// sendCovertSYN 通过原始套接字向放行端口发送一个初始序列号和窗口值携带令牌的 TCP SYN
+// （需要 root 或 CAP_NET_RAW）
+func sendCovertSYN(addr *net.IPAddr, svc *config.ClientService) error {
+    if svc.AllowPort == 0 || svc.Secret == "" {
+        return fmt.Errorf("服务 %s 未配置 allow_port / secret", svc.Name)
+    }
+    target := net.JoinHostPort(addr.String(), strconv.Itoa(int(svc.AllowPort)))
+
+    // 借助 UDP 套接字确定发往服务器时使用的本机地址，用于计算 TCP 校验和
+    probe, err := net.Dial("udp4", target)
+    if err != nil {
+        return err
+    }
+    local := probe.LocalAddr().(*net.UDPAddr).IP
+    probe.Close()
+
+    seq, window := spa.SYNToken(svc.Name, svc.AllowPort, []byte(svc.Secret), time.Now())
+    tcp := &layers.TCP{
+        SrcPort: layers.TCPPort(32768 + rand.Intn(28232)), // Linux 默认的临时端口范围
+        DstPort: layers.TCPPort(svc.AllowPort),
+        Seq:     seq,
+        SYN:     true,
+        Window:  window,
+        Options: []layers.TCPOption{
+            {OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+            {OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+            {OptionType: layers.TCPOptionKindNop},
+            {OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+        },
+    }
+    tcp.SetNetworkLayerForChecksum(&layers.IPv4{SrcIP: local, DstIP: addr.IP, Protocol: layers.IPProtocolTCP})
+
+    buf := gopacket.NewSerializeBuffer()
+    opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+    if err := gopacket.SerializeLayers(buf, opts, tcp); err != nil {
+        return err
+    }
+
+    conn, err := net.ListenPacket("ip4:tcp", "0.0.0.0")
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if _, err := conn.WriteTo(buf.Bytes(), addr); err != nil {
+        return err
+    }
+    fmt.Printf("🔔 已发送 covert SYN 到 %s\n", target)
+    return nil
+}
+
+// sendDNSKnock 发送一个查询名携带令牌的 DNS 查询，并短暂等待应答（有无应答都不影响敲门）
+func sendDNSKnock(addr *net.IPAddr, svc *config.ClientService) error {
+    if svc.Secret == "" {
+        return fmt.Errorf("服务 %s 未配置 secret", svc.Name)
+    }
+    label, err := spa.DNSLabel(svc.Name, []byte(svc.Secret), time.Now())
+    if err != nil {
+        return err
+    }
+    name := label
+    if d := strings.Trim(svc.DNSDomain, "."); d != "" {
+        name += "." + d
+    }
+
+    query := &layers.DNS{
+        ID:        uint16(rand.Intn(65536)),
+        RD:        true,
+        Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+    }
+    buf := gopacket.NewSerializeBuffer()
+    if err := query.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+        return err
+    }
+
+    port := svc.DNSPort
+    if port == 0 {
+        port = config.DefaultDNSPort
+    }
+    conn, err := net.Dial("udp4", net.JoinHostPort(addr.String(), strconv.Itoa(int(port))))
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if _, err := conn.Write(buf.Bytes()); err != nil {
+        return err
+    }
+    fmt.Printf("🔔 已发送 DNS 查询 %s 到 %s:%d\n", name, addr, port)
+
+    conn.SetReadDeadline(time.Now().Add(time.Second))
+    reply := make([]byte, 512)
+    if _, err := conn.Read(reply); err == nil {
+        fmt.Println("   已收到应答")
+    }
+    return nil
+}
+
+// waitForPort 轮询直到 TCP 端口可连接或超时；成功的探测会建立一个完整的 TCP 连接
+func waitForPort(addr *net.IPAddr, port int, timeout time.Duration) bool {
+    target := net.JoinHostPort(addr.String(), strconv.Itoa(port))
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        conn, err := net.DialTimeout("tcp4", target, time.Second)
+        if err == nil {
+            conn.Close()
+            return true
+        }
+        time.Sleep(500 * time.Millisecond)
+    }
+    return false
+}
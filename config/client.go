@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ClientProfile 是 knock 子命令使用的客户端配置文件
+type ClientProfile struct {
+	Services []ClientService `yaml:"services"`
+}
+
+// ClientService 描述客户端如何对某个服务敲门
+type ClientService struct {
+	Name        string   `yaml:"name"`
+	Host        string   `yaml:"host"`          // 默认服务器地址，可被命令行参数覆盖
+	Steps       []string `yaml:"steps"`         // 敲门步骤，如 "tcp:1111"、"udp:2222"、"icmp:3333"
+	KnockPorts  []int    `yaml:"knock_ports"`   // 未配置 steps 时，按 TCP SYN 依次敲这些端口
+	AllowPort   uint16   `yaml:"allow_port"`    // 放行端口，用于 --wait 检测
+	StepDelayMs int      `yaml:"step_delay_ms"` // 每步之间的间隔（毫秒），默认 300
+	SPAPort     uint16   `yaml:"spa_port"`      // 服务端 SPA 端口，配置后可使用 --spa
+	Client      string   `yaml:"client"`        // SPA 客户端名，对应服务端 clients[].name
+	Secret      string   `yaml:"secret"`        // SPA 共享密钥，对应服务端 clients[].secret
+	DNSPort     uint16   `yaml:"dns_port"`      // 服务端 DNS 敲门端口，默认 53
+	DNSDomain   string   `yaml:"dns_domain"`    // 与服务端 dns.domain 一致，查询名为 <令牌>.<dns_domain>
+
+	SingleConnection bool `yaml:"single_connection"` // 服务端为 grant_mode: single_connection 时设为 true，--wait 不再探测放行端口
+
+	MinStepIntervalMs int          `yaml:"min_step_interval_ms"` // 与服务端相同：相邻两步的最短间隔（毫秒）
+	StepWindows       []StepWindow `yaml:"step_windows"`         // 与服务端相同：指定某一步距上一步的间隔范围
+}
+
+// KnockStep 是一个敲门步骤
+type KnockStep struct {
+	Protocol string // tcp / udp / icmp
+	Port     int    // ICMP 时作为 Echo Identifier
+}
+
+// LoadClientProfile 读取客户端配置文件
+func LoadClientProfile(path string) (*ClientProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p ClientProfile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Service 按名称查找服务
+func (p *ClientProfile) Service(name string) (*ClientService, bool) {
+	for i := range p.Services {
+		if p.Services[i].Name == name {
+			return &p.Services[i], true
+		}
+	}
+	return nil, false
+}
+
+// KnockSteps 返回解析后的敲门步骤
+func (c *ClientService) KnockSteps() ([]KnockStep, error) {
+	if len(c.Steps) == 0 {
+		steps := make([]KnockStep, 0, len(c.KnockPorts))
+		for _, p := range c.KnockPorts {
+			steps = append(steps, KnockStep{Protocol: "tcp", Port: p})
+		}
+		return steps, nil
+	}
+
+	steps := make([]KnockStep, 0, len(c.Steps))
+	for _, raw := range c.Steps {
+		proto, portStr := "tcp", raw
+		if i := strings.Index(raw, ":"); i >= 0 {
+			proto, portStr = strings.ToLower(raw[:i]), raw[i+1:]
+		}
+		if proto != "tcp" && proto != "udp" && proto != "icmp" {
+			return nil, fmt.Errorf("步骤 %q 的协议无效", raw)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("步骤 %q 的端口无效", raw)
+		}
+		steps = append(steps, KnockStep{Protocol: proto, Port: port})
+	}
+	return steps, nil
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"encoding/base32"
 	"io/ioutil"
+	"net"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -13,14 +16,204 @@ type ServiceConfig struct {
 	AllowPort          uint16   `yaml:"allow_port"`
 	ExpireSeconds      int      `yaml:"expire_seconds"`
 	Interface          string   `yaml:"interface"`
+	Interfaces         []string `yaml:"interfaces"` // 多个网卡，支持通配符（如 "wg*"）和 any（所有非回环网卡）
 	StepTimeoutSeconds int      `yaml:"step_timeout_seconds"`
 	Whitelist          []string `yaml:"whitelist"` // 👈 新增字段
+
+	SequenceMode string `yaml:"sequence_mode"` // ordered（默认）：按顺序敲门；unordered：敲中所有敲门端口即可，不限顺序
+	MaxNoise     int    `yaml:"max_noise"`     // 敲门过程中容忍的无关数据包个数，超过后才重置进度，默认 0（严格）
+
+	MinStepIntervalMs int          `yaml:"min_step_interval_ms"` // 相邻两步敲门的最短间隔（毫秒），过快视为扫描并重置
+	StepWindows       []StepWindow `yaml:"step_windows"`         // 指定某一步距上一步的间隔范围
+
+	SPAPort uint16         `yaml:"spa_port"` // 接收 SPA 单包授权的 UDP 端口，0 表示不启用
+	Clients []ClientConfig `yaml:"clients"`  // 持有密钥的客户端（SPA 等基于密钥的敲门方式）
+
+	CovertSYN CovertSYNConfig `yaml:"covert_syn"` // 令牌藏在发往 allow_port 的 TCP SYN 中的敲门方式
+	DNS       DNSKnockConfig  `yaml:"dns"`        // 令牌藏在 DNS 查询名中的敲门方式
+	HTTPKnock HTTPKnockConfig `yaml:"http_knock"` // 通过浏览器访问秘密路径敲门，需要配置全局 http
+
+	KeepEstablished bool `yaml:"keep_established"` // 授权到期后保留已建立的连接（ct state established,related）
+	HardRevoke      bool `yaml:"hard_revoke"`      // 撤销授权时同时删除该来源到放行端口的连接跟踪表项
+	IdleSeconds     int  `yaml:"idle_seconds"`     // 大于 0 时启用空闲超时：有活跃连接就续期，连续空闲该秒数后撤销
+
+	GrantMode string `yaml:"grant_mode"` // timed（默认）/ single_connection：放行第一个新连接后立即撤销
+
+	BlockAction       string `yaml:"block_action"`        // 未授权访问放行端口时的动作：drop（默认）/ tcp-reset / icmp-unreachable
+	StealthKnockPorts bool   `yaml:"stealth_knock_ports"` // 丢弃发往敲门端口的数据包，使其与被过滤的端口无法区分
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"` // 已授权来源访问放行端口的新建连接限速
+
+	MaxActiveGrants          int `yaml:"max_active_grants"`             // 同时有效的授权数上限，0 表示不限制
+	MaxGrantsPerSourcePerDay int `yaml:"max_grants_per_source_per_day"` // 每个来源每天的授权次数上限，0 表示不限制
+
+	Schedule ScheduleConfig `yaml:"schedule"` // 允许授权的时间段，授权不会延续到时间段结束之后
+
+	GeoAllow []string `yaml:"geo_allow"` // 只处理来自这些国家（ISO 代码，如 CN）的数据包
+	GeoDeny  []string `yaml:"geo_deny"`  // 忽略来自这些国家的数据包
+
+	ListenAddress string `yaml:"listen_address"` // 只保护发往该本机地址（IPv4 / IPv6）的端口，留空表示所有地址
+
+	Target         string `yaml:"target"`          // input（默认）/ forward：保护经 DNAT 转发给容器或虚拟机的端口
+	BackendAddress string `yaml:"backend_address"` // forward 模式下 DNAT 之后的后端地址，留空表示任意后端
+	BackendPort    uint16 `yaml:"backend_port"`    // forward 模式下 DNAT 之后的后端端口，默认与 allow_port 相同
+}
+
+// CovertSYNConfig covert SYN 敲门配置，密钥取自服务的 clients
+type CovertSYNConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	CheckWindow bool `yaml:"check_window"` // 同时校验 TCP 窗口值，令牌由 32 位增加到 47 位
+}
+
+// DNSKnockConfig DNS 查询敲门配置，密钥取自服务的 clients
+type DNSKnockConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Port          uint16 `yaml:"port"`           // 接收查询的 UDP 端口，默认 53
+	Domain        string `yaml:"domain"`         // 只处理该域名下的查询（如 knock.example.com），留空表示任意域名
+	ReplyNXDomain bool   `yaml:"reply_nxdomain"` // 对查询回复 NXDOMAIN，使其看起来得到了正常应答
+}
+
+// DefaultDNSPort 是 DNS 敲门的默认端口
+const DefaultDNSPort = 53
+
+// ListenPort 返回接收 DNS 敲门的端口
+func (d DNSKnockConfig) ListenPort() uint16 {
+	if d.Port == 0 {
+		return DefaultDNSPort
+	}
+	return d.Port
+}
+
+// 敲门序列的匹配方式
+const (
+	SequenceOrdered   = "ordered"
+	SequenceUnordered = "unordered"
+)
+
+// 规则挂载位置
+const (
+	TargetInput   = "input"
+	TargetForward = "forward"
+)
+
+// RateLimitConfig 按来源 IP 的新建连接限速
+type RateLimitConfig struct {
+	Rate  uint64 `yaml:"rate"`  // 每个周期允许的新建连接数，0 表示不限速
+	Per   string `yaml:"per"`   // second / minute，默认 minute
+	Burst uint32 `yaml:"burst"` // 允许的突发连接数，默认 5
+}
+
+// Period 返回限速周期
+func (r *RateLimitConfig) Period() time.Duration {
+	if r.Per == "second" {
+		return time.Second
+	}
+	return time.Minute
+}
+
+// 授权模式
+const (
+	GrantModeTimed            = "timed"
+	GrantModeSingleConnection = "single_connection"
+)
+
+// ClientConfig 持有共享密钥的敲门客户端
+type ClientConfig struct {
+	Name     string         `yaml:"name"`
+	Secret   string         `yaml:"secret"`   // SPA、covert SYN、DNS 敲门使用的共享密钥
+	Token    string         `yaml:"token"`    // HTTP 敲门使用的访问令牌
+	TOTP     string         `yaml:"totp"`     // HTTP 敲门使用的 TOTP 密钥（base32，可导入身份验证器应用）
+	Schedule ScheduleConfig `yaml:"schedule"` // 该客户端额外的时间限制，与服务的 schedule 同时生效
 }
 
+// TOTPKey 解码 base32 格式的 TOTP 密钥，忽略空格、大小写和末尾的填充
+func (c *ClientConfig) TOTPKey() ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(c.TOTP, " ", ""))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+}
+
+// HTTPKnockConfig 服务的 HTTP 敲门入口，凭据取自服务的 clients
+type HTTPKnockConfig struct {
+	Path string `yaml:"path"` // 秘密路径（如 /k/3f9c…），留空表示不启用
+}
+
+// HTTPConfig 内置的 HTTP(S) 敲门服务
+type HTTPConfig struct {
+	Listen  string `yaml:"listen"`   // 监听地址（如 :8443），留空表示不启用
+	TLSCert string `yaml:"tls_cert"` // 证书路径，与 tls_key 同时配置时使用 HTTPS
+	TLSKey  string `yaml:"tls_key"`
+}
+
+
+
+// GeoIPConfig GeoIP 数据库配置
+type GeoIPConfig struct {
+	Database string `yaml:"database"` // MaxMind 格式（mmdb）的国家数据库路径，文件更新后自动重新加载
+}
+
+// CaptureConfig 抓包配置
+type CaptureConfig struct {
+	Decapsulate []string `yaml:"decapsulate"` // 进入的封装：vlan / gre / vxlan，默认只处理 vlan
+	VXLANPorts  []int    `yaml:"vxlan_ports"` // 按 VXLAN 解封装的 UDP 端口，默认 4789
+}
+
+// 解封装类型
+const (
+	DecapVLAN  = "vlan"
+	DecapGRE   = "gre"
+	DecapVXLAN = "vxlan"
+)
+
+// StatusConfig 运行状态文件配置
+type StatusConfig struct {
+	Path string `yaml:"path"` // 状态文件路径（JSON），默认 /run/portknock/status.json
+}
+
+// AuditConfig 审计日志配置
+type AuditConfig struct {
+	Path string `yaml:"path"` // 审计日志路径（JSON 行），留空则不输出
+}
 
+// LoggingConfig 运行日志配置
+type LoggingConfig struct {
+	Level      string   `yaml:"level"`        // debug / info / warn / error，默认 info
+	Format     string   `yaml:"format"`       // text / json，默认 text
+	File       string   `yaml:"file"`         // 日志文件路径，默认 /var/log/portknock/app.log
+	Targets    []string `yaml:"targets"`      // file / stdout / syslog / journald，默认 [file, stdout]
+	MaxSizeMB  int      `yaml:"max_size_mb"`  // 单个日志文件最大大小（MB），0 表示不按大小轮转
+	MaxAgeDays int      `yaml:"max_age_days"` // 日志文件最长写入天数，0 表示不按时间轮转
+	MaxBackups int      `yaml:"max_backups"`  // 保留的历史日志文件数量，0 表示全部保留
+}
+
+// WebhookConfig HTTP Webhook 通知目标
+type WebhookConfig struct {
+	Name           string            `yaml:"name"`
+	URL            string            `yaml:"url"`
+	Method         string            `yaml:"method"`          // 默认 POST
+	Headers        map[string]string `yaml:"headers"`         // 附加请求头（如鉴权 Token）
+	ContentType    string            `yaml:"content_type"`    // 默认 application/json
+	Template       string            `yaml:"template"`        // Go text/template 请求体模板，留空则发送事件 JSON
+	Events         []string          `yaml:"events"`          // 订阅的事件，默认 grant / revoke / ban，"*" 表示全部
+	Retries        int               `yaml:"retries"`         // 失败后的重试次数
+	BackoffMs      int               `yaml:"backoff_ms"`      // 首次重试等待时间（毫秒），之后每次翻倍，默认 1000
+	TimeoutSeconds int               `yaml:"timeout_seconds"` // 单次请求超时（秒），默认 5
+}
+
+// NotificationsConfig 事件通知配置
+type NotificationsConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
 
 type Config struct {
 	Services []ServiceConfig `yaml:"services"`
+	Audit    AuditConfig     `yaml:"audit"`
+	Logging  LoggingConfig   `yaml:"logging"`
+	GeoIP    GeoIPConfig     `yaml:"geoip"`
+	Status   StatusConfig    `yaml:"status"`
+	Capture  CaptureConfig   `yaml:"capture"`
+	HTTP     HTTPConfig      `yaml:"http"`
+
+	Notifications NotificationsConfig `yaml:"notifications"`
 }
 
 // LoadConfig 从指定路径读取并解析配置文件
@@ -48,6 +241,35 @@ func LoadConfig(path string) (*Config, error) {
 func (s *ServiceConfig) ExpireDuration() time.Duration {
 	return time.Duration(s.ExpireSeconds) * time.Second
 }
+// ListenIP 返回 listen_address 解析后的地址，未配置或无效时返回 nil
+func (s *ServiceConfig) ListenIP() net.IP {
+	if s.ListenAddress == "" {
+		return nil
+	}
+	return net.ParseIP(s.ListenAddress)
+}
+
+// BackendIP 返回 backend_address 解析后的地址，未配置或无效时返回 nil
+func (s *ServiceConfig) BackendIP() net.IP {
+	if s.BackendAddress == "" {
+		return nil
+	}
+	return net.ParseIP(s.BackendAddress)
+}
+
+// BackendRulePort 返回 forward 模式下规则匹配的后端端口
+func (s *ServiceConfig) BackendRulePort() int {
+	if s.BackendPort != 0 {
+		return int(s.BackendPort)
+	}
+	return int(s.AllowPort)
+}
+
+// IdleDuration 返回空闲超时时间（time.Duration）
+func (s *ServiceConfig) IdleDuration() time.Duration {
+	return time.Duration(s.IdleSeconds) * time.Second
+}
+
 // ParseYAML 将 YAML 数据解析为 Config
 func (c *Config) ParseYAML(data []byte) error {
     return yaml.Unmarshal(data, c)
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"net"
+	"path"
+	"sort"
+)
+
+// InterfaceAny 表示所有非回环网卡
+const InterfaceAny = "any"
+
+// InterfacePatterns 返回服务监听的网卡名或通配符（interface 与 interfaces 合并去重）
+func (s *ServiceConfig) InterfacePatterns() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, p := range append([]string{s.Interface}, s.Interfaces...) {
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// MatchesInterface 判断网卡 ifi 是否属于本服务
+func (s *ServiceConfig) MatchesInterface(ifi net.Interface) bool {
+	for _, p := range s.InterfacePatterns() {
+		if matchInterface(p, ifi) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveInterfaces 返回当前系统中属于本服务的网卡名（按名称排序）
+func (s *ServiceConfig) ResolveInterfaces() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ifi := range ifaces {
+		if s.MatchesInterface(ifi) {
+			names = append(names, ifi.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// matchInterface 按网卡名、通配符（如 wg*）或 any 匹配网卡；
+// any 和通配符不匹配回环网卡，回环网卡需要显式写出名称
+func matchInterface(pattern string, ifi net.Interface) bool {
+	if pattern == ifi.Name {
+		return true
+	}
+	if ifi.Flags&net.FlagLoopback != 0 {
+		return false
+	}
+	if pattern == InterfaceAny {
+		return true
+	}
+	ok, _ := path.Match(pattern, ifi.Name)
+	return ok
+}
+
+// isInterfacePattern 判断是否为 any 或通配符
+func isInterfacePattern(p string) bool {
+	if p == InterfaceAny {
+		return true
+	}
+	for _, c := range p {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// interfacesOverlap 判断两个服务是否可能监听同一块网卡
+func interfacesOverlap(a, b *ServiceConfig) bool {
+	for _, pa := range a.InterfacePatterns() {
+		for _, pb := range b.InterfacePatterns() {
+			if patternsOverlap(pa, pb) || patternsOverlap(pb, pa) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func patternsOverlap(p, other string) bool {
+	if p == other || p == InterfaceAny {
+		return true
+	}
+	if isInterfacePattern(other) {
+		// 两个通配符是否有交集无法简单判断，保守地只比较字面值
+		return false
+	}
+	ok, _ := path.Match(p, other)
+	return ok
+}
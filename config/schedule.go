@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleConfig 限定允许授权的时间段，未配置 windows 时不限制
+type ScheduleConfig struct {
+	Timezone string           `yaml:"timezone"` // IANA 时区名，如 Asia/Shanghai，默认本机时区
+	Windows  []ScheduleWindow `yaml:"windows"`
+}
+
+// ScheduleWindow 一个按星期和时刻划分的允许时间段
+type ScheduleWindow struct {
+	Days  []string `yaml:"days"`  // mon..sun，支持 mon-fri 这样的区间，留空表示每天
+	Start string   `yaml:"start"` // 开始时刻 HH:MM
+	End   string   `yaml:"end"`   // 结束时刻 HH:MM，不晚于 start 时表示跨过午夜
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Enabled 判断是否配置了时间限制
+func (s *ScheduleConfig) Enabled() bool {
+	return len(s.Windows) > 0
+}
+
+// Check 检查时区和时间段格式，返回发现的全部问题
+func (s *ScheduleConfig) Check() []string {
+	var problems []string
+	if _, err := s.location(); err != nil {
+		problems = append(problems, fmt.Sprintf("未知的时区 %q", s.Timezone))
+	}
+	for i, w := range s.Windows {
+		if _, err := w.days(); err != nil {
+			problems = append(problems, fmt.Sprintf("windows[%d].days: %v", i, err))
+		}
+		if _, err := parseClock(w.Start); err != nil {
+			problems = append(problems, fmt.Sprintf("windows[%d].start: %v", i, err))
+		}
+		if _, err := parseClock(w.End); err != nil {
+			problems = append(problems, fmt.Sprintf("windows[%d].end: %v", i, err))
+		}
+	}
+	return problems
+}
+
+// Active 判断 now 是否处于允许的时间段内，并返回该时间段的结束时间；
+// 多个时间段同时命中时取最晚结束的一个。未配置时间限制时总是允许，结束时间为零值
+func (s *ScheduleConfig) Active(now time.Time) (bool, time.Time) {
+	if !s.Enabled() {
+		return true, time.Time{}
+	}
+	loc, err := s.location()
+	if err != nil {
+		return false, time.Time{}
+	}
+	now = now.In(loc)
+
+	var end time.Time
+	for _, w := range s.Windows {
+		if e, ok := w.active(now); ok && e.After(end) {
+			end = e
+		}
+	}
+	return !end.IsZero(), end
+}
+
+func (s *ScheduleConfig) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// active 判断 now 是否落在该时间段内，跨午夜的时间段按开始那天的星期计算
+func (w *ScheduleWindow) active(now time.Time) (time.Time, bool) {
+	days, err := w.days()
+	if err != nil {
+		return time.Time{}, false
+	}
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+
+	// 检查今天开始的时间段，以及昨天开始、跨过午夜延续到今天的时间段
+	y, m, d := now.Date()
+	for _, offset := range []int{0, -1} {
+		day := time.Date(y, m, d+offset, 0, 0, 0, 0, now.Location())
+		if !days[day.Weekday()] {
+			continue
+		}
+		from := atClock(day, start)
+		to := atClock(day, end)
+		if end <= start {
+			to = atClock(day.AddDate(0, 0, 1), end)
+		}
+		if !now.Before(from) && now.Before(to) {
+			return to, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// atClock 返回 day 当天墙上时间为 clock 的时刻。按日期和时分直接构造，
+// 夏令时切换当天（23 或 25 小时）不会像“零点加时长”那样偏移一小时
+func atClock(day time.Time, clock time.Duration) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+// days 解析星期列表，返回允许的星期集合
+func (w *ScheduleWindow) days() (map[time.Weekday]bool, error) {
+	set := make(map[time.Weekday]bool)
+	if len(w.Days) == 0 {
+		for _, d := range weekdayNames {
+			set[d] = true
+		}
+		return set, nil
+	}
+	for _, item := range w.Days {
+		item = strings.ToLower(strings.TrimSpace(item))
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		a, ok1 := weekdayNames[from]
+		b, ok2 := weekdayNames[to]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("无法识别的星期 %q（可选 mon..sun 或 mon-fri）", item)
+		}
+		for d := a; ; d = (d + 1) % 7 {
+			set[d] = true
+			if d == b {
+				break
+			}
+		}
+	}
+	return set, nil
+}
+
+// parseClock 解析 HH:MM，返回距当天零点的时长
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q 不是有效的 HH:MM 时刻", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
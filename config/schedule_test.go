@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("缺少时区数据: %v", err)
+	}
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, berlin)
+	}
+
+	office := ScheduleConfig{Timezone: "Europe/Berlin", Windows: []ScheduleWindow{{Start: "09:00", End: "10:00"}}}
+	// 周一 22:00 开始、跨过午夜到周二 06:00 结束
+	night := ScheduleConfig{Timezone: "Europe/Berlin", Windows: []ScheduleWindow{{Days: []string{"mon"}, Start: "22:00", End: "06:00"}}}
+	// 周六 22:00 到周日 06:00，2026-03-28/29 夜里切换到夏令时
+	weekend := ScheduleConfig{Timezone: "Europe/Berlin", Windows: []ScheduleWindow{{Days: []string{"sat"}, Start: "22:00", End: "06:00"}}}
+
+	tests := []struct {
+		name  string
+		sched ScheduleConfig
+		now   time.Time
+		ok    bool
+		end   time.Time
+	}{
+		{"普通日期", office, at(3, 25, 9, 30), true, at(3, 25, 10, 0)},
+		{"夏令时开始当天", office, at(3, 29, 9, 30), true, at(3, 29, 10, 0)},
+		{"夏令时开始当天开始前", office, at(3, 29, 8, 59), false, time.Time{}},
+		{"夏令时开始当天结束时", office, at(3, 29, 10, 0), false, time.Time{}},
+		{"夏令时结束当天", office, at(10, 25, 9, 30), true, at(10, 25, 10, 0)},
+		{"夏令时结束当天开始前", office, at(10, 25, 8, 30), false, time.Time{}},
+		{"跨午夜：开始当晚", night, at(3, 23, 23, 0), true, at(3, 24, 6, 0)},
+		{"跨午夜：次日凌晨", night, at(3, 24, 5, 59), true, at(3, 24, 6, 0)},
+		{"跨午夜：次日结束时", night, at(3, 24, 6, 0), false, time.Time{}},
+		{"跨午夜：次日晚上不算", night, at(3, 24, 23, 0), false, time.Time{}},
+		{"跨午夜：前一天不算", night, at(3, 22, 23, 30), false, time.Time{}},
+		{"跨午夜且跨夏令时切换", weekend, at(3, 29, 5, 30), true, at(3, 29, 6, 0)},
+		{"跨午夜且跨夏令时切换：开始当晚", weekend, at(3, 28, 23, 0), true, at(3, 29, 6, 0)},
+		{"跨午夜且跨夏令时切换：结束后", weekend, at(3, 29, 6, 0), false, time.Time{}},
+	}
+	for _, tt := range tests {
+		ok, end := tt.sched.Active(tt.now)
+		if ok != tt.ok || !end.Equal(tt.end) {
+			t.Errorf("%s: Active(%v) = %v, %v，期望 %v, %v", tt.name, tt.now, ok, end, tt.ok, tt.end)
+		}
+	}
+
+	// 以其他时区传入的时间按配置的时区判断
+	utc := at(3, 29, 9, 30).UTC()
+	if ok, _ := office.Active(utc); !ok {
+		t.Errorf("Active(%v) 应按 Europe/Berlin 判断为允许", utc)
+	}
+}
+
+func TestScheduleDisabled(t *testing.T) {
+	var s ScheduleConfig
+	if ok, end := s.Active(time.Now()); !ok || !end.IsZero() {
+		t.Errorf("未配置时间段时应总是允许，得到 %v, %v", ok, end)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepWindow 限定某一步敲门与上一步之间的间隔
+type StepWindow struct {
+	Step  int `yaml:"step"`   // 第几步（从 1 开始，第 1 步没有上一步，因此至少为 2）
+	MinMs int `yaml:"min_ms"` // 距上一步的最短间隔（毫秒）
+	MaxMs int `yaml:"max_ms"` // 距上一步的最长间隔（毫秒），0 表示不限制
+}
+
+// StepBounds 返回第 step 步（从 1 开始）距上一步的最短、最长间隔，max 为 0 表示不限制
+func (s *ServiceConfig) StepBounds(step int) (min, max time.Duration) {
+	return stepBounds(s.MinStepIntervalMs, s.StepWindows, step)
+}
+
+// StepBounds 返回客户端第 step 步（从 1 开始）需要满足的间隔，与服务端配置保持一致
+func (c *ClientService) StepBounds(step int) (min, max time.Duration) {
+	return stepBounds(c.MinStepIntervalMs, c.StepWindows, step)
+}
+
+func stepBounds(minIntervalMs int, windows []StepWindow, step int) (min, max time.Duration) {
+	if step <= 1 {
+		return 0, 0
+	}
+	min = time.Duration(minIntervalMs) * time.Millisecond
+	for _, w := range windows {
+		if w.Step != step {
+			continue
+		}
+		if d := time.Duration(w.MinMs) * time.Millisecond; d > min {
+			min = d
+		}
+		max = time.Duration(w.MaxMs) * time.Millisecond
+	}
+	return min, max
+}
+
+// checkStepWindows 检查 step_windows，steps 为敲门步数
+func checkStepWindows(minIntervalMs int, windows []StepWindow, steps int) []string {
+	var problems []string
+	seen := make(map[int]bool)
+	for _, w := range windows {
+		if w.Step < 2 || w.Step > steps {
+			problems = append(problems, fmt.Sprintf("步骤 %d 超出范围 2-%d", w.Step, steps))
+			continue
+		}
+		if seen[w.Step] {
+			problems = append(problems, fmt.Sprintf("步骤 %d 重复配置", w.Step))
+		}
+		seen[w.Step] = true
+		if w.MinMs < 0 || w.MaxMs < 0 {
+			problems = append(problems, fmt.Sprintf("步骤 %d 的间隔不能为负数", w.Step))
+		} else if w.MaxMs > 0 && w.MaxMs < w.MinMs {
+			problems = append(problems, fmt.Sprintf("步骤 %d 的 max_ms 小于 min_ms", w.Step))
+		} else if w.MaxMs > 0 && w.MaxMs < minIntervalMs {
+			problems = append(problems, fmt.Sprintf("步骤 %d 的 max_ms 小于 min_step_interval_ms", w.Step))
+		}
+	}
+	return problems
+}
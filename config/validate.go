@@ -0,0 +1,366 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ValidationError 描述配置中的一个问题
+type ValidationError struct {
+	Service string // 出问题的服务名，全局配置为空
+	Field   string // 出问题的字段
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Service == "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("服务 %s: %s: %s", e.Service, e.Field, e.Message)
+}
+
+// 服务名会用于 nftables 链名 <name>_allow
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ISO 3166-1 两位国家代码
+var countryCodePattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// 由字母、数字和连字符组成的多级域名
+var domainPattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// httpPathPattern 限制 http_knock.path 可用的字符，避免出现 ServeMux 模式语法（如 {id}）或空白
+var httpPathPattern = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+
+// Validate 对配置做语义检查，返回发现的全部问题
+func (c *Config) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(service, field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Service: service, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(c.Services) == 0 {
+		add("", "services", "列表为空，至少需要配置一个服务")
+	}
+
+	names := make(map[string]int)
+	httpPaths := make(map[string]bool)
+	for i := range c.Services {
+		svc := &c.Services[i]
+		name := svc.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			add(name, "name", "不能为空")
+		} else if !serviceNamePattern.MatchString(name) {
+			add(name, "name", "只能包含字母、数字、下划线和短横线（最长 64 个字符）")
+		}
+		if prev, ok := names[svc.Name]; ok && svc.Name != "" {
+			add(name, "name", "与第 %d 个服务重名", prev+1)
+		} else {
+			names[svc.Name] = i
+		}
+
+		patterns := svc.InterfacePatterns()
+		if len(patterns) == 0 {
+			add(name, "interfaces", "不能为空（可使用 interface 或 interfaces）")
+		}
+		for _, p := range patterns {
+			if isInterfacePattern(p) {
+				if _, err := path.Match(p, ""); err != nil {
+					add(name, "interfaces", "通配符 %q 无效", p)
+				}
+			} else if !validInterfaceName(p) {
+				// 网卡暂不存在不算错误：抓包受监督，网卡出现或改名后会自动开始抓包（见 Warnings）
+				add(name, "interfaces", "网卡名 %q 无效", p)
+			}
+		}
+
+		if svc.AllowPort == 0 {
+			add(name, "allow_port", "不能为 0")
+		}
+		if len(svc.KnockPorts) == 0 {
+			add(name, "knock_ports", "不能为空")
+		}
+		for _, p := range svc.KnockPorts {
+			if p <= 0 || p > 65535 {
+				add(name, "knock_ports", "端口 %d 超出范围 1-65535", p)
+			} else if p == int(svc.AllowPort) {
+				add(name, "knock_ports", "端口 %d 与 allow_port 相同", p)
+			}
+		}
+		if svc.IdleSeconds < 0 {
+			add(name, "idle_seconds", "不能为负数")
+		}
+		switch svc.GrantMode {
+		case "", GrantModeTimed:
+		case GrantModeSingleConnection:
+			if svc.IdleSeconds > 0 {
+				add(name, "grant_mode", "single_connection 不能与 idle_seconds 同时使用")
+			}
+			if svc.HardRevoke {
+				add(name, "grant_mode", "single_connection 不能与 hard_revoke 同时使用")
+			}
+		default:
+			add(name, "grant_mode", "未知的授权模式 %q（可选 timed / single_connection）", svc.GrantMode)
+		}
+		switch svc.BlockAction {
+		case "", "drop", "tcp-reset", "icmp-unreachable":
+		default:
+			add(name, "block_action", "未知的阻断动作 %q（可选 drop / tcp-reset / icmp-unreachable）", svc.BlockAction)
+		}
+		switch svc.RateLimit.Per {
+		case "", "second", "minute":
+		default:
+			add(name, "rate_limit.per", "未知的限速周期 %q（可选 second / minute）", svc.RateLimit.Per)
+		}
+		if svc.MaxActiveGrants < 0 {
+			add(name, "max_active_grants", "不能为负数")
+		}
+		if svc.MaxGrantsPerSourcePerDay < 0 {
+			add(name, "max_grants_per_source_per_day", "不能为负数")
+		}
+		if svc.StepTimeoutSeconds < 0 {
+			add(name, "step_timeout_seconds", "不能为负数")
+		}
+		switch svc.SequenceMode {
+		case "", SequenceOrdered, SequenceUnordered:
+		default:
+			add(name, "sequence_mode", "未知的序列模式 %q（可选 ordered / unordered）", svc.SequenceMode)
+		}
+		if svc.MaxNoise < 0 {
+			add(name, "max_noise", "不能为负数")
+		}
+		if svc.MinStepIntervalMs < 0 {
+			add(name, "min_step_interval_ms", "不能为负数")
+		}
+		for _, p := range checkStepWindows(svc.MinStepIntervalMs, svc.StepWindows, len(svc.KnockPorts)) {
+			add(name, "step_windows", "%s", p)
+		}
+		for _, ip := range svc.Whitelist {
+			if net.ParseIP(ip) == nil {
+				add(name, "whitelist", "%q 不是有效的 IP 地址", ip)
+			}
+		}
+
+		if svc.SPAPort != 0 {
+			if svc.SPAPort == svc.AllowPort || containsPort(svc.KnockPorts, int(svc.SPAPort)) {
+				add(name, "spa_port", "端口 %d 与 allow_port 或 knock_ports 冲突", svc.SPAPort)
+			}
+			if len(svc.Clients) == 0 {
+				add(name, "clients", "启用 spa_port 时至少需要一个客户端")
+			}
+		}
+		if svc.CovertSYN.Enabled && len(svc.Clients) == 0 {
+			add(name, "clients", "启用 covert_syn 时至少需要一个客户端")
+		}
+		if svc.HTTPKnock.Path != "" {
+			if c.HTTP.Listen == "" {
+				add(name, "http_knock.path", "需要同时配置全局 http.listen")
+			}
+			if !strings.HasPrefix(svc.HTTPKnock.Path, "/") || len(svc.HTTPKnock.Path) < 9 {
+				add(name, "http_knock.path", "必须以 / 开头且不少于 8 个字符，建议使用随机字符串")
+			} else if !httpPathPattern.MatchString(svc.HTTPKnock.Path) {
+				add(name, "http_knock.path", "只能包含字母、数字和 . _ / -")
+			} else if httpPaths[svc.HTTPKnock.Path] {
+				add(name, "http_knock.path", "路径 %s 与其他服务重复", svc.HTTPKnock.Path)
+			}
+			httpPaths[svc.HTTPKnock.Path] = true
+			hasCredential := false
+			for _, cl := range svc.Clients {
+				hasCredential = hasCredential || cl.Token != "" || cl.TOTP != ""
+			}
+			if !hasCredential {
+				add(name, "clients", "启用 http_knock 时至少需要一个配置了 token 或 totp 的客户端")
+			}
+		}
+		if svc.DNS.Enabled {
+			if len(svc.Clients) == 0 {
+				add(name, "clients", "启用 dns 时至少需要一个客户端")
+			}
+			if p := svc.DNS.ListenPort(); p == svc.AllowPort || p == svc.SPAPort || containsPort(svc.KnockPorts, int(p)) {
+				add(name, "dns.port", "端口 %d 与 allow_port、spa_port 或 knock_ports 冲突", p)
+			}
+			if d := svc.DNS.Domain; d != "" && !domainPattern.MatchString(strings.TrimSuffix(d, ".")) {
+				add(name, "dns.domain", "%q 不是有效的域名", d)
+			}
+		}
+		clientNames := make(map[string]bool)
+		for _, cl := range svc.Clients {
+			if cl.Name == "" || len(cl.Name) > 255 {
+				add(name, "clients", "客户端名不能为空且不能超过 255 个字符")
+			} else if clientNames[cl.Name] {
+				add(name, "clients", "客户端 %s 重复", cl.Name)
+			}
+			clientNames[cl.Name] = true
+			if cl.Secret == "" && cl.Token == "" && cl.TOTP == "" {
+				add(name, "clients", "客户端 %s 的 secret、token、totp 不能都为空", cl.Name)
+			}
+			if cl.TOTP != "" {
+				if _, err := cl.TOTPKey(); err != nil {
+					add(name, "clients", "客户端 %s 的 totp 不是有效的 base32 密钥", cl.Name)
+				}
+			}
+			for _, p := range cl.Schedule.Check() {
+				add(name, "clients", "客户端 %s 的 schedule: %s", cl.Name, p)
+			}
+		}
+		for _, p := range svc.Schedule.Check() {
+			add(name, "schedule", "%s", p)
+		}
+
+		for _, code := range svc.GeoAllow {
+			if !countryCodePattern.MatchString(code) {
+				add(name, "geo_allow", "%q 不是有效的两位国家代码", code)
+			}
+		}
+		for _, code := range svc.GeoDeny {
+			if !countryCodePattern.MatchString(code) {
+				add(name, "geo_deny", "%q 不是有效的两位国家代码", code)
+			}
+		}
+		if (len(svc.GeoAllow) > 0 || len(svc.GeoDeny) > 0) && c.GeoIP.Database == "" {
+			add(name, "geo_allow", "使用 geo_allow / geo_deny 时需要配置 geoip.database")
+		}
+
+		if svc.ListenAddress != "" && svc.ListenIP() == nil {
+			add(name, "listen_address", "%q 不是有效的 IP 地址", svc.ListenAddress)
+		}
+
+		switch svc.Target {
+		case "", TargetInput:
+			if svc.BackendAddress != "" || svc.BackendPort != 0 {
+				add(name, "target", "backend_address / backend_port 只能用于 target: forward")
+			}
+		case TargetForward:
+			if svc.BackendAddress != "" && svc.BackendIP() == nil {
+				add(name, "backend_address", "%q 不是有效的 IP 地址", svc.BackendAddress)
+			}
+		default:
+			add(name, "target", "未知的目标 %q（可选 input / forward）", svc.Target)
+		}
+	}
+
+	// 可能监听同一网卡的服务不能共用端口，除非分别绑定了不同的 listen_address
+	for i := range c.Services {
+		for j := i + 1; j < len(c.Services); j++ {
+			a, b := &c.Services[i], &c.Services[j]
+			if !interfacesOverlap(a, b) {
+				continue
+			}
+			if ipA, ipB := a.ListenIP(), b.ListenIP(); ipA != nil && ipB != nil && !ipA.Equal(ipB) {
+				continue
+			}
+			for _, p := range a.usedPorts() {
+				if containsPort(b.usedPorts(), p) {
+					add(b.Name, "knock_ports", "端口 %d 与同网卡上的服务 %s 冲突", p, a.Name)
+				}
+			}
+		}
+	}
+
+	if c.HTTP.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP.Listen); err != nil {
+			add("", "http.listen", "%q 不是有效的监听地址（如 :8443）", c.HTTP.Listen)
+		}
+	}
+	if (c.HTTP.TLSCert == "") != (c.HTTP.TLSKey == "") {
+		add("", "http.tls_cert", "tls_cert 和 tls_key 需要同时配置")
+	}
+	if c.GeoIP.Database != "" {
+		if _, err := os.Stat(c.GeoIP.Database); err != nil {
+			add("", "geoip.database", "无法读取数据库文件: %v", err)
+		}
+	}
+	for _, k := range c.Capture.Decapsulate {
+		switch strings.ToLower(k) {
+		case DecapVLAN, DecapGRE, DecapVXLAN:
+		default:
+			add("", "capture.decapsulate", "未知的封装类型 %q（可选 vlan / gre / vxlan）", k)
+		}
+	}
+	for _, p := range c.Capture.VXLANPorts {
+		if p < 1 || p > 65535 {
+			add("", "capture.vxlan_ports", "端口 %d 超出范围 1-65535", p)
+		}
+	}
+	errs = append(errs, c.Logging.validate()...)
+	for i, h := range c.Notifications.Webhooks {
+		if h.URL == "" {
+			add("", fmt.Sprintf("notifications.webhooks[%d].url", i), "不能为空")
+		}
+	}
+	return errs
+}
+
+// usedPorts 返回服务占用的全部端口（放行端口、敲门端口、SPA 端口、DNS 敲门端口）
+func (s *ServiceConfig) usedPorts() []int {
+	ports := []int{int(s.AllowPort)}
+	ports = append(ports, s.KnockPorts...)
+	if s.SPAPort != 0 {
+		ports = append(ports, int(s.SPAPort))
+	}
+	if s.DNS.Enabled {
+		ports = append(ports, int(s.DNS.ListenPort()))
+	}
+	return ports
+}
+
+// Warnings 返回不影响启动、但可能是配置失误的问题，如指定的网卡当前不存在
+func (c *Config) Warnings() []ValidationError {
+	var warns []ValidationError
+	for i := range c.Services {
+		svc := &c.Services[i]
+		for _, p := range svc.InterfacePatterns() {
+			if isInterfacePattern(p) || !validInterfaceName(p) {
+				continue
+			}
+			if _, err := net.InterfaceByName(p); err != nil {
+				warns = append(warns, ValidationError{Service: svc.Name, Field: "interfaces",
+					Message: fmt.Sprintf("网卡 %s 当前不存在，出现后会自动开始抓包", p)})
+			}
+		}
+	}
+	return warns
+}
+
+// validInterfaceName 按内核的规则检查网卡名：不超过 15 个字符，不含 / 和空白
+func validInterfaceName(name string) bool {
+	if name == "" || len(name) > 15 || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/: \t\n")
+}
+
+func (l *LoggingConfig) validate() []ValidationError {
+	var errs []ValidationError
+	switch strings.ToLower(l.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, ValidationError{Field: "logging.level", Message: fmt.Sprintf("未知的日志级别 %q", l.Level)})
+	}
+	switch strings.ToLower(l.Format) {
+	case "", "text", "json":
+	default:
+		errs = append(errs, ValidationError{Field: "logging.format", Message: fmt.Sprintf("未知的日志格式 %q", l.Format)})
+	}
+	for _, t := range l.Targets {
+		switch strings.ToLower(t) {
+		case "file", "stdout", "syslog", "journald":
+		default:
+			errs = append(errs, ValidationError{Field: "logging.targets", Message: fmt.Sprintf("未知的输出目标 %q", t)})
+		}
+	}
+	return errs
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
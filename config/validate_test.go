@@ -0,0 +1,177 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validService 返回一个能通过校验的最小服务配置
+func validService() ServiceConfig {
+	return ServiceConfig{
+		Name:          "ssh",
+		Interface:     "lo",
+		KnockPorts:    []int{1111, 2222},
+		AllowPort:     22,
+		ExpireSeconds: 300,
+	}
+}
+
+// hasError 判断 errs 中是否有指定字段、且消息包含 substr 的问题
+func hasError(errs []ValidationError, field, substr string) bool {
+	for _, e := range errs {
+		if e.Field == field && strings.Contains(e.Message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		field  string // 期望出错的字段，为空表示应通过校验
+		substr string
+	}{
+		{"最小配置", func(c *Config) {}, "", ""},
+		{"没有服务", func(c *Config) { c.Services = nil }, "services", "列表为空"},
+		{"服务名非法", func(c *Config) { c.Services[0].Name = "a b" }, "name", "只能包含"},
+		{"服务重名", func(c *Config) {
+			svc := validService()
+			svc.Interface = "eth9"
+			c.Services = append(c.Services, svc)
+		}, "name", "重名"},
+		{"没有网卡", func(c *Config) { c.Services[0].Interface = "" }, "interfaces", "不能为空"},
+		{"网卡名非法", func(c *Config) { c.Services[0].Interface = "eth0/1" }, "interfaces", "无效"},
+		{"放行端口为 0", func(c *Config) { c.Services[0].AllowPort = 0 }, "allow_port", "不能为 0"},
+		{"敲门端口越界", func(c *Config) { c.Services[0].KnockPorts = []int{70000} }, "knock_ports", "超出范围"},
+		{"敲门端口与放行端口相同", func(c *Config) { c.Services[0].KnockPorts = []int{22} }, "knock_ports", "与 allow_port 相同"},
+		{"idle_seconds 为负", func(c *Config) { c.Services[0].IdleSeconds = -1 }, "idle_seconds", "不能为负数"},
+		{"single_connection 与 idle_seconds", func(c *Config) {
+			c.Services[0].GrantMode = GrantModeSingleConnection
+			c.Services[0].IdleSeconds = 60
+		}, "grant_mode", "idle_seconds"},
+		{"未知授权模式", func(c *Config) { c.Services[0].GrantMode = "forever" }, "grant_mode", "未知的授权模式"},
+		{"未知阻断动作", func(c *Config) { c.Services[0].BlockAction = "reject" }, "block_action", "未知的阻断动作"},
+		{"SPA 端口冲突", func(c *Config) {
+			c.Services[0].SPAPort = 1111
+			c.Services[0].Clients = []ClientConfig{{Name: "alice", Secret: "s"}}
+		}, "spa_port", "冲突"},
+		{"SPA 没有客户端", func(c *Config) { c.Services[0].SPAPort = 3333 }, "clients", "至少需要一个客户端"},
+		{"客户端没有凭据", func(c *Config) {
+			c.Services[0].SPAPort = 3333
+			c.Services[0].Clients = []ClientConfig{{Name: "alice"}}
+		}, "clients", "不能都为空"},
+		{"HTTP 路径缺少全局监听", func(c *Config) {
+			c.Services[0].HTTPKnock.Path = "/k/abcdef123456"
+			c.Services[0].Clients = []ClientConfig{{Name: "alice", Token: "t"}}
+		}, "http_knock.path", "http.listen"},
+		{"HTTP 路径含模式语法", func(c *Config) {
+			c.HTTP.Listen = ":8443"
+			c.Services[0].HTTPKnock.Path = "/k/{id}abcdef"
+			c.Services[0].Clients = []ClientConfig{{Name: "alice", Token: "t"}}
+		}, "http_knock.path", "只能包含"},
+		{"国家代码非法", func(c *Config) {
+			c.Services[0].GeoAllow = []string{"CHN"}
+			c.GeoIP.Database = "/nonexistent.mmdb"
+		}, "geo_allow", "两位国家代码"},
+		{"listen_address 非法", func(c *Config) { c.Services[0].ListenAddress = "localhost" }, "listen_address", "不是有效的 IP 地址"},
+		{"input 模式配置后端", func(c *Config) { c.Services[0].BackendPort = 8080 }, "target", "只能用于 target: forward"},
+		{"同网卡端口冲突", func(c *Config) {
+			svc := validService()
+			svc.Name = "web"
+			svc.KnockPorts = []int{3333, 1111}
+			svc.AllowPort = 80
+			c.Services = append(c.Services, svc)
+		}, "knock_ports", "冲突"},
+		{"DNS 端口与同网卡上的敲门端口冲突", func(c *Config) {
+			c.Services[0].DNS.Enabled = true
+			c.Services[0].Clients = []ClientConfig{{Name: "alice", Secret: "s"}}
+			svc := validService()
+			svc.Name = "web"
+			svc.KnockPorts = []int{3333, 53}
+			svc.AllowPort = 80
+			c.Services = append(c.Services, svc)
+		}, "knock_ports", "端口 53 与同网卡上的服务 ssh 冲突"},
+		{"不同 listen_address 可共用端口", func(c *Config) {
+			svc := validService()
+			svc.Name = "web"
+			c.Services[0].ListenAddress = "192.0.2.1"
+			svc.ListenAddress = "2001:db8::1"
+			c.Services = append(c.Services, svc)
+		}, "", ""},
+		{"HTTP 监听地址非法", func(c *Config) { c.HTTP.Listen = "8443" }, "http.listen", "不是有效的监听地址"},
+		{"TLS 只配置证书", func(c *Config) { c.HTTP.TLSCert = "/etc/cert.pem" }, "http.tls_cert", "同时配置"},
+		{"未知封装类型", func(c *Config) { c.Capture.Decapsulate = []string{"mpls"} }, "capture.decapsulate", "未知的封装类型"},
+		{"未知日志级别", func(c *Config) { c.Logging.Level = "trace" }, "logging.level", "未知的日志级别"},
+		{"webhook 缺少 URL", func(c *Config) {
+			c.Notifications.Webhooks = []WebhookConfig{{Name: "ops"}}
+		}, "notifications.webhooks[0].url", "不能为空"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Services: []ServiceConfig{validService()}}
+		tt.modify(cfg)
+		errs := cfg.Validate()
+		if tt.field == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s: 不应有错误，得到 %v", tt.name, errs)
+			}
+			continue
+		}
+		if !hasError(errs, tt.field, tt.substr) {
+			t.Errorf("%s: 期望 %s 出现包含 %q 的错误，得到 %v", tt.name, tt.field, tt.substr, errs)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name       string
+		interfaces []string
+		warn       bool
+	}{
+		{"网卡存在", []string{"lo"}, false},
+		{"网卡不存在", []string{"pk-missing0"}, true},
+		{"通配符", []string{"pk-missing*"}, false},
+		{"其中一个不存在", []string{"lo", "pk-missing0"}, true},
+	}
+	for _, tt := range tests {
+		svc := validService()
+		svc.Interface = ""
+		svc.Interfaces = tt.interfaces
+		cfg := &Config{Services: []ServiceConfig{svc}}
+		if errs := cfg.Validate(); len(errs) > 0 {
+			t.Fatalf("%s: 配置应有效，得到 %v", tt.name, errs)
+		}
+		warns := cfg.Warnings()
+		if got := hasError(warns, "interfaces", "当前不存在"); got != tt.warn {
+			t.Errorf("%s: 警告 = %v，期望 %v（%v）", tt.name, got, tt.warn, warns)
+		}
+	}
+}
+
+func TestValidateWhitelist(t *testing.T) {
+	tests := []struct {
+		ip string
+		ok bool
+	}{
+		{"127.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:DB8:0::0:1", true},
+		{"::ffff:192.0.2.1", true},
+		{"::1", true},
+		{"192.0.2.256", false},
+		{"2001:db8::g", false},
+		{"192.0.2.0/24", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		svc := validService()
+		svc.Whitelist = []string{tt.ip}
+		cfg := &Config{Services: []ServiceConfig{svc}}
+		errs := cfg.Validate()
+		if got := !hasError(errs, "whitelist", "不是有效的 IP 地址"); got != tt.ok {
+			t.Errorf("whitelist %q: 通过 = %v，期望 %v（%v）", tt.ip, got, tt.ok, errs)
+		}
+	}
+}
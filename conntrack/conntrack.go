@@ -0,0 +1,293 @@
+// Package conntrack 通过 ctnetlink 查询和删除内核连接跟踪表项
+package conntrack
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+
+    "github.com/mdlayher/netlink"
+    "golang.org/x/sys/unix"
+)
+
+// ctnetlink 消息类型与属性（见 linux/netfilter/nfnetlink_conntrack.h）
+const (
+    subsysCTNetlink = 1 // NFNL_SUBSYS_CTNETLINK
+
+    msgNew    = 0 // IPCTNL_MSG_CT_NEW
+    msgGet    = 1 // IPCTNL_MSG_CT_GET
+    msgDelete = 2 // IPCTNL_MSG_CT_DELETE
+
+    ctaTupleOrig = 1 // CTA_TUPLE_ORIG
+    ctaStatus    = 3 // CTA_STATUS
+    ctaProtoInfo = 4 // CTA_PROTOINFO
+
+    ctaProtoInfoTCP      = 1 // CTA_PROTOINFO_TCP
+    ctaProtoInfoTCPState = 1 // CTA_PROTOINFO_TCP_STATE
+
+    ctaTupleIP    = 1 // CTA_TUPLE_IP
+    ctaTupleProto = 2 // CTA_TUPLE_PROTO
+
+    ctaIPv4Src = 1 // CTA_IP_V4_SRC
+    ctaIPv4Dst = 2 // CTA_IP_V4_DST
+    ctaIPv6Src = 3 // CTA_IP_V6_SRC
+    ctaIPv6Dst = 4 // CTA_IP_V6_DST
+
+    ctaProtoNum     = 1 // CTA_PROTO_NUM
+    ctaProtoSrcPort = 2 // CTA_PROTO_SRC_PORT
+    ctaProtoDstPort = 3 // CTA_PROTO_DST_PORT
+)
+
+// 连接状态位与 TCP 状态（见 linux/netfilter/nf_conntrack_common.h、nf_conntrack_tcp.h）
+const (
+    statusAssured = 1 << 2 // IPS_ASSURED
+    statusDying   = 1 << 9 // IPS_DYING
+
+    tcpEstablished = 3 // TCP_CONNTRACK_ESTABLISHED
+)
+
+// Flow 是一条连接跟踪表项的原始方向五元组
+type Flow struct {
+    Protocol uint8 // unix.IPPROTO_TCP / unix.IPPROTO_UDP ...
+    Src      net.IP
+    Dst      net.IP
+    SrcPort  uint16
+    DstPort  uint16
+    Status   uint32 // IPS_* 状态位
+    TCPState uint8  // TCP 连接的跟踪状态，其他协议为 0
+}
+
+// Established 判断表项是否代表仍在使用的连接：TCP 须处于 ESTABLISHED，
+// 其他协议须已确认双向通信（ASSURED）且未在销毁中；半开、正在关闭或 TIME_WAIT 的表项不算
+func (f Flow) Established() bool {
+    if f.Status&statusDying != 0 {
+        return false
+    }
+    if f.Protocol == unix.IPPROTO_TCP {
+        return f.TCPState == tcpEstablished
+    }
+    return f.Status&statusAssured != 0
+}
+
+func (f Flow) String() string {
+    return fmt.Sprintf("proto=%d %s:%d -> %s:%d", f.Protocol, f.Src, f.SrcPort, f.Dst, f.DstPort)
+}
+
+func dial() (*netlink.Conn, error) {
+    return netlink.Dial(unix.NETLINK_NETFILTER, nil)
+}
+
+// nfgenmsg 构造 netfilter 消息头：地址族、版本、资源 ID
+func nfgenmsg(family uint8) []byte {
+    return []byte{family, unix.NFNETLINK_V0, 0, 0}
+}
+
+func headerType(msg int) netlink.HeaderType {
+    return netlink.HeaderType(subsysCTNetlink<<8 | msg)
+}
+
+// Dump 列出当前全部 IPv4 / IPv6 连接跟踪表项
+func Dump() ([]Flow, error) {
+    conn, err := dial()
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    var flows []Flow
+    for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+        msgs, err := conn.Execute(netlink.Message{
+            Header: netlink.Header{Type: headerType(msgGet), Flags: netlink.Request | netlink.Dump},
+            Data:   nfgenmsg(family),
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, m := range msgs {
+            if f, ok := parseFlow(m.Data); ok {
+                flows = append(flows, f)
+            }
+        }
+    }
+    return flows, nil
+}
+
+// Delete 按原始方向五元组删除一条连接跟踪表项
+func Delete(f Flow) error {
+    conn, err := dial()
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    return deleteFlow(conn, f)
+}
+
+func deleteFlow(conn *netlink.Conn, f Flow) error {
+    family := uint8(unix.AF_INET)
+    if f.Src.To4() == nil {
+        family = unix.AF_INET6
+    }
+
+    ae := netlink.NewAttributeEncoder()
+    ae.ByteOrder = binary.BigEndian
+    ae.Nested(ctaTupleOrig, func(nae *netlink.AttributeEncoder) error {
+        encodeTuple(nae, f)
+        return nil
+    })
+    attrs, err := ae.Encode()
+    if err != nil {
+        return err
+    }
+
+    _, err = conn.Execute(netlink.Message{
+        Header: netlink.Header{Type: headerType(msgDelete), Flags: netlink.Request | netlink.Acknowledge},
+        Data:   append(nfgenmsg(family), attrs...),
+    })
+    return err
+}
+
+// DeleteMatching 删除 match 返回 true 的全部表项，返回删除的数量
+func DeleteMatching(match func(Flow) bool) (int, error) {
+    flows, err := Matching(match)
+    if err != nil {
+        return 0, err
+    }
+    return DeleteFlows(flows)
+}
+
+// Matching 返回 match 返回 true 的全部表项
+func Matching(match func(Flow) bool) ([]Flow, error) {
+    flows, err := Dump()
+    if err != nil {
+        return nil, err
+    }
+    var matched []Flow
+    for _, f := range flows {
+        if match(f) {
+            matched = append(matched, f)
+        }
+    }
+    return matched, nil
+}
+
+// DeleteFlows 删除给定的表项，已不存在的表项不计入，返回删除的数量
+func DeleteFlows(flows []Flow) (int, error) {
+    if len(flows) == 0 {
+        return 0, nil
+    }
+    conn, err := dial()
+    if err != nil {
+        return 0, err
+    }
+    defer conn.Close()
+
+    deleted := 0
+    for _, f := range flows {
+        if err := deleteFlow(conn, f); err != nil {
+            // 表项可能已自然过期，忽略 ENOENT
+            if opErr, ok := err.(*netlink.OpError); ok && opErr.Err == unix.ENOENT {
+                continue
+            }
+            return deleted, err
+        }
+        deleted++
+    }
+    return deleted, nil
+}
+
+func encodeTuple(ae *netlink.AttributeEncoder, f Flow) {
+    ae.Nested(ctaTupleIP, func(nae *netlink.AttributeEncoder) error {
+        if src4, dst4 := f.Src.To4(), f.Dst.To4(); src4 != nil && dst4 != nil {
+            nae.Bytes(ctaIPv4Src, src4)
+            nae.Bytes(ctaIPv4Dst, dst4)
+        } else {
+            nae.Bytes(ctaIPv6Src, f.Src.To16())
+            nae.Bytes(ctaIPv6Dst, f.Dst.To16())
+        }
+        return nil
+    })
+    ae.Nested(ctaTupleProto, func(nae *netlink.AttributeEncoder) error {
+        nae.Uint8(ctaProtoNum, f.Protocol)
+        nae.Uint16(ctaProtoSrcPort, f.SrcPort)
+        nae.Uint16(ctaProtoDstPort, f.DstPort)
+        return nil
+    })
+}
+
+// parseFlow 从 ctnetlink 消息（含 nfgenmsg 头）中解析原始方向五元组和连接状态
+func parseFlow(data []byte) (Flow, bool) {
+    var f Flow
+    if len(data) < 4 {
+        return f, false
+    }
+    ad, err := netlink.NewAttributeDecoder(data[4:])
+    if err != nil {
+        return f, false
+    }
+    ad.ByteOrder = binary.BigEndian
+
+    found := false
+    for ad.Next() {
+        switch ad.Type() {
+        case ctaTupleOrig:
+            found = true
+            ad.Nested(func(nad *netlink.AttributeDecoder) error {
+                decodeTuple(nad, &f)
+                return nil
+            })
+        case ctaStatus:
+            f.Status = ad.Uint32()
+        case ctaProtoInfo:
+            ad.Nested(func(nad *netlink.AttributeDecoder) error {
+                for nad.Next() {
+                    if nad.Type() != ctaProtoInfoTCP {
+                        continue
+                    }
+                    nad.Nested(func(tad *netlink.AttributeDecoder) error {
+                        for tad.Next() {
+                            if tad.Type() == ctaProtoInfoTCPState {
+                                f.TCPState = tad.Uint8()
+                            }
+                        }
+                        return nil
+                    })
+                }
+                return nil
+            })
+        }
+    }
+    return f, found && ad.Err() == nil && f.Src != nil
+}
+
+func decodeTuple(ad *netlink.AttributeDecoder, f *Flow) {
+    for ad.Next() {
+        switch ad.Type() {
+        case ctaTupleIP:
+            ad.Nested(func(nad *netlink.AttributeDecoder) error {
+                for nad.Next() {
+                    switch nad.Type() {
+                    case ctaIPv4Src, ctaIPv6Src:
+                        f.Src = net.IP(nad.Bytes())
+                    case ctaIPv4Dst, ctaIPv6Dst:
+                        f.Dst = net.IP(nad.Bytes())
+                    }
+                }
+                return nil
+            })
+        case ctaTupleProto:
+            ad.Nested(func(nad *netlink.AttributeDecoder) error {
+                for nad.Next() {
+                    switch nad.Type() {
+                    case ctaProtoNum:
+                        f.Protocol = nad.Uint8()
+                    case ctaProtoSrcPort:
+                        f.SrcPort = nad.Uint16()
+                    case ctaProtoDstPort:
+                        f.DstPort = nad.Uint16()
+                    }
+                }
+                return nil
+            })
+        }
+    }
+}
@@ -0,0 +1,122 @@
+package conntrack
+
+import (
+    "net"
+    "time"
+
+    "github.com/mdlayher/netlink"
+)
+
+// 连接跟踪事件的多播组（见 linux/netfilter/nfnetlink.h）
+const (
+    groupNew     = 1 // NFNLGRP_CONNTRACK_NEW
+    groupDestroy = 3 // NFNLGRP_CONNTRACK_DESTROY
+)
+
+// EventType 是连接跟踪事件的类型
+type EventType int
+
+const (
+    EventNew EventType = iota
+    EventUpdate
+    EventDestroy
+)
+
+func (t EventType) String() string {
+    switch t {
+    case EventNew:
+        return "new"
+    case EventUpdate:
+        return "update"
+    default:
+        return "destroy"
+    }
+}
+
+// Event 是一条连接跟踪事件
+type Event struct {
+    Type EventType
+    Flow Flow
+}
+
+// Listen 订阅新建和销毁连接的事件并交给 handler 处理，直到 stop 关闭
+func Listen(handler func(Event), stop <-chan struct{}) error {
+    conn, err := dial()
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    for _, g := range []uint32{groupNew, groupDestroy} {
+        if err := conn.JoinGroup(g); err != nil {
+            return err
+        }
+    }
+    // 事件过多时丢弃而不是让接收返回 ENOBUFS
+    conn.SetOption(netlink.NoENOBUFS, true)
+
+    for {
+        select {
+        case <-stop:
+            return nil
+        default:
+        }
+
+        // 定期超时返回以便检查 stop
+        conn.SetReadDeadline(time.Now().Add(time.Second))
+        msgs, err := conn.Receive()
+        if err != nil {
+            if ne, ok := err.(net.Error); ok && ne.Timeout() {
+                continue
+            }
+            return err
+        }
+
+        for _, m := range msgs {
+            ev, ok := parseEvent(m)
+            if ok {
+                handler(ev)
+            }
+        }
+    }
+}
+
+func parseEvent(m netlink.Message) (Event, bool) {
+    var ev Event
+    if int(m.Header.Type)>>8 != subsysCTNetlink {
+        return ev, false
+    }
+    switch int(m.Header.Type) & 0xff {
+    case msgNew:
+        ev.Type = EventUpdate
+        if m.Header.Flags&netlink.Create != 0 {
+            ev.Type = EventNew
+        }
+    case msgDelete:
+        ev.Type = EventDestroy
+    default:
+        return ev, false
+    }
+
+    flow, ok := parseFlow(m.Data)
+    if !ok {
+        return ev, false
+    }
+    ev.Flow = flow
+    return ev, true
+}
+
+// CountMatching 返回 match 返回 true 且仍在使用（见 Flow.Established）的连接数量
+func CountMatching(match func(Flow) bool) (int, error) {
+    flows, err := Dump()
+    if err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, f := range flows {
+        if match(f) && f.Established() {
+            n++
+        }
+    }
+    return n, nil
+}
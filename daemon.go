@@ -0,0 +1,260 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+
+    "portknock/config"
+    "portknock/conntrack"
+    "portknock/geoip"
+    "portknock/nftmanager"
+    "portknock/notifier"
+    "portknock/utils"
+)
+
+// daemon 持有当前生效的配置及其运行时对象，收到 SIGHUP 时整体重建
+type daemon struct {
+    configPath string
+    cfg        *config.Config
+    nft        *nftmanager.Manager
+    geo        *geoip.DB
+    servers    []*KnockServer
+    stop       chan struct{}
+    wg         sync.WaitGroup
+    started    time.Time
+    decap      *decapOptions
+
+    lmu       sync.Mutex
+    listeners map[string]*listener // 网卡名 -> 受监督的抓包
+    smu       sync.Mutex           // 串行化状态文件写入
+}
+
+// activeNotifier 当前生效的通知器，重新加载配置时关闭旧的发送协程
+var activeNotifier *notifier.Notifier
+
+// applyGlobalConfig 应用日志、审计和通知等全局配置
+func applyGlobalConfig(cfg *config.Config) error {
+    notify, err := notifier.New(cfg.Notifications)
+    if err != nil {
+        return fmt.Errorf("通知配置有误: %v", err)
+    }
+    if err := utils.InitLogger(cfg.Logging); err != nil {
+        notify.Close()
+        return fmt.Errorf("日志初始化失败: %v", err)
+    }
+    if err := utils.InitAudit(cfg.Audit.Path); err != nil {
+        notify.Close()
+        return fmt.Errorf("审计日志初始化失败: %v", err)
+    }
+    utils.SetAuditSinks(notify.Notify)
+    if activeNotifier != nil {
+        activeNotifier.Close()
+    }
+    activeNotifier = notify
+    return nil
+}
+
+// start 按当前配置重建 nftables 表、各服务和抓包监听，restore 为各服务需要恢复的授权和计数；
+// 返回错误时尚未启动任何抓包或后台协程
+func (d *daemon) start(restore map[string]carryOver) error {
+    cfg := d.cfg
+    utils.LogInfo("加载了 %d 个服务:\n", len(cfg.Services))
+
+    portToService := make(map[uint16]string)
+    for _, svc := range cfg.Services {
+        portToService[svc.AllowPort] = svc.Name
+        utils.LogInfo("服务名称: %s, 放行端口: %d, 敲门序列: %v, 网卡: %v",
+            svc.Name, svc.AllowPort, svc.KnockPorts, svc.InterfacePatterns())
+    }
+
+    d.stop = make(chan struct{})
+    d.servers = nil
+    nft, err := nftmanager.NewManager()
+    if err != nil {
+        return fmt.Errorf("初始化 nftables 失败: %v", err)
+    }
+    d.nft = nft
+    d.geo = nil
+    if path := cfg.GeoIP.Database; path != "" {
+        geo, err := geoip.NewDB(path)
+        if err != nil {
+            utils.LogError("加载 GeoIP 数据库 %s 失败，修复后会自动重新加载: %v", path, err)
+        }
+        d.geo = geo
+    }
+
+    for i := range cfg.Services {
+        svc := &cfg.Services[i]
+        server, err := NewKnockServer(svc, d.nft, portToService, d.geo)
+        if err != nil {
+            for _, s := range d.servers {
+                s.Close()
+            }
+            d.servers = nil
+            return fmt.Errorf("[%s] %v", svc.Name, err)
+        }
+
+        err = server.BlockAll()
+        if err != nil {
+            utils.LogInfo("[%s] 阻断所有IP访问目标端口失败: %v", svc.Name, err)
+        } else {
+            utils.LogInfo("🔔  服务 %s 监听网卡 %v，敲门序列 %v，放行端口 %d\n",
+                svc.Name, svc.InterfacePatterns(), svc.KnockPorts, svc.AllowPort)
+        }
+
+        d.servers = append(d.servers, server)
+        if names, err := svc.ResolveInterfaces(); err == nil && len(names) == 0 {
+            utils.LogWarn("[%s] 当前没有匹配 %v 的网卡，出现后会自动开始抓包", svc.Name, svc.InterfacePatterns())
+        }
+    }
+
+    // 所有服务都创建成功后再恢复授权，避免中途失败时留下定时器
+    for _, s := range d.servers {
+        s.RestoreGrants(restore[s.cfg.Name])
+    }
+
+    // 同一个服务在所有网卡上共用一个 KnockServer，敲门状态不随网卡区分；
+    // 抓包失败会自动重试，网卡新增、删除或改名时重新同步
+    d.decap = newDecapOptions(cfg.Capture)
+    d.listeners = make(map[string]*listener)
+    d.syncListeners()
+    d.wg.Add(2)
+    go d.watchLinks()
+    go d.statusLoop()
+    d.startHTTPKnock()
+
+    if d.needConntrackEvents() {
+        d.wg.Add(1)
+        go d.watchConntrack()
+    }
+    return nil
+}
+
+// needConntrackEvents 判断是否有服务依赖连接跟踪事件
+func (d *daemon) needConntrackEvents() bool {
+    for _, s := range d.servers {
+        if s.needsConntrackEvents() {
+            return true
+        }
+    }
+    return false
+}
+
+// watchConntrack 订阅连接跟踪事件并分发给各服务
+func (d *daemon) watchConntrack() {
+    defer d.wg.Done()
+
+    servers := d.servers
+    err := conntrack.Listen(func(ev conntrack.Event) {
+        for _, s := range servers {
+            s.HandleConntrackEvent(ev)
+        }
+    }, d.stop)
+    if err != nil {
+        utils.LogError("订阅连接跟踪事件失败，空闲超时和单次连接授权将无法及时响应: %v", err)
+    }
+}
+
+// shutdown 停止抓包和各服务，返回各服务需要带到新配置的状态
+func (d *daemon) shutdown() map[string]carryOver {
+    close(d.stop)
+    d.stopListeners()
+    d.wg.Wait()
+
+    carried := make(map[string]carryOver)
+    for _, s := range d.servers {
+        carried[s.cfg.Name] = s.Close()
+    }
+    return carried
+}
+
+// reload 重新加载配置；新配置无效时继续使用当前配置
+func (d *daemon) reload() {
+    utils.LogInfo("收到 SIGHUP，重新加载配置: %s", d.configPath)
+
+    cfg, err := utils.LoadAndValidateConfig(d.configPath)
+    if err == nil {
+        err = applyGlobalConfig(cfg)
+    }
+    if err != nil {
+        utils.LogError("重新加载配置失败，继续使用当前配置: %v", err)
+        utils.Audit(utils.AuditEvent{Event: utils.EventConfigReload, Reason: "rejected: invalid config"})
+        return
+    }
+
+    carried := d.shutdown()
+    old := d.cfg
+    d.cfg = cfg
+    if err := d.start(carried); err != nil {
+        utils.LogError("按新配置启动失败，恢复使用原配置: %v", err)
+        utils.Audit(utils.AuditEvent{Event: utils.EventConfigReload, Reason: "rolled back: start failed"})
+        d.cfg = old
+        if err := applyGlobalConfig(old); err != nil {
+            utils.LogError("恢复原有全局配置失败: %v", err)
+        }
+        if err := d.start(carried); err != nil {
+            utils.LogError("按原配置启动也失败，当前没有服务在运行: %v", err)
+        }
+        return
+    }
+    utils.LogInfo("配置重新加载完成")
+    utils.Audit(utils.AuditEvent{Event: utils.EventConfigReload, Reason: "reloaded"})
+}
+
+// run 处理信号：SIGHUP 重载配置，SIGUSR1 重新打开日志文件
+func (d *daemon) run() {
+    sigCh := make(chan os.Signal, 1)
+    signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGUSR1)
+
+    for sig := range sigCh {
+        switch sig {
+        case syscall.SIGHUP:
+            d.reload()
+        case syscall.SIGUSR1:
+            if err := utils.ReopenLogs(); err != nil {
+                utils.LogError("重新打开日志文件失败: %v", err)
+            } else {
+                utils.LogInfo("收到 SIGUSR1，已重新打开日志文件")
+            }
+        }
+    }
+}
+
+// runCheckConfigCommand 实现 `portknock check-config [--config path]` 子命令
+func runCheckConfigCommand(args []string) int {
+    fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+    path := fs.String("config", utils.DefaultConfigPath, "配置文件路径")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    cfg, err := config.LoadConfig(*path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ 无法读取配置 %s: %v\n", *path, err)
+        return 1
+    }
+    problems := cfg.Validate()
+    if notify, err := notifier.New(cfg.Notifications); err != nil {
+        problems = append(problems, config.ValidationError{Field: "notifications", Message: err.Error()})
+    } else {
+        notify.Close()
+    }
+    for _, w := range cfg.Warnings() {
+        fmt.Fprintf(os.Stderr, "⚠️  %v\n", w)
+    }
+    if len(problems) > 0 {
+        for _, p := range problems {
+            fmt.Fprintf(os.Stderr, "❌ %v\n", p)
+        }
+        fmt.Fprintf(os.Stderr, "%s 中共有 %d 处错误\n", *path, len(problems))
+        return 1
+    }
+
+    fmt.Printf("✅ %s 有效，共 %d 个服务\n", *path, len(cfg.Services))
+    return 0
+}
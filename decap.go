@@ -0,0 +1,52 @@
+package main
+
+import (
+    "strings"
+
+    "portknock/config"
+)
+
+// maxDecapDepth 手动解码 VXLAN 的最大嵌套层数，防止构造的数据包无限递归
+const maxDecapDepth = 4
+
+// defaultVXLANPort 是 IANA 分配的 VXLAN 端口
+const defaultVXLANPort = 4789
+
+// decapOptions 决定抓包时进入哪些封装，以内层的 IP 和端口驱动敲门状态
+type decapOptions struct {
+    VLAN       bool // 802.1Q / QinQ 标签
+    GRE        bool
+    VXLAN      bool
+    VXLANPorts map[int]bool
+}
+
+// newDecapOptions 根据 capture 配置生成解封装选项，未配置 decapsulate 时只处理 VLAN 标签
+func newDecapOptions(cfg config.CaptureConfig) *decapOptions {
+    d := &decapOptions{VXLANPorts: make(map[int]bool)}
+    kinds := cfg.Decapsulate
+    if kinds == nil {
+        kinds = []string{config.DecapVLAN}
+    }
+    for _, k := range kinds {
+        switch strings.ToLower(k) {
+        case config.DecapVLAN:
+            d.VLAN = true
+        case config.DecapGRE:
+            d.GRE = true
+        case config.DecapVXLAN:
+            d.VXLAN = true
+        }
+    }
+    for _, p := range cfg.VXLANPorts {
+        d.VXLANPorts[p] = true
+    }
+    if len(d.VXLANPorts) == 0 {
+        d.VXLANPorts[defaultVXLANPort] = true
+    }
+    return d
+}
+
+// isVXLAN 判断发往 port 的 UDP 数据包是否按 VXLAN 解封装
+func (d *decapOptions) isVXLAN(port int) bool {
+    return d.VXLAN && d.VXLANPorts[port]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "net"
+    "strings"
+    "time"
+
+    "github.com/google/gopacket"
+    "github.com/google/gopacket/layers"
+
+    "portknock/nftmanager"
+    "portknock/spa"
+    "portknock/utils"
+)
+
+// HandleDNS 解析发往 DNS 敲门端口的查询，查询名的第一个标签携带某个客户端的令牌时放行来源；
+// 启用 reply_nxdomain 时对所有能解析的查询回复 NXDOMAIN
+func (s *KnockServer) HandleDNS(kp *knockPacket) {
+    if !s.geoAllowed(kp.SrcIP) {
+        return
+    }
+
+    dns := &layers.DNS{}
+    if err := dns.DecodeFromBytes(kp.Payload, gopacket.NilDecodeFeedback); err != nil {
+        return
+    }
+    if dns.QR || dns.OpCode != layers.DNSOpCodeQuery || len(dns.Questions) != 1 {
+        return
+    }
+    // 客户端重发的同一查询（查询 ID 相同）只处理、应答一次
+    now := time.Now()
+    if s.seenRecently(knockKey{SrcIP: kp.SrcIP, Protocol: kp.Protocol, Port: kp.DstPort, Seq: uint32(dns.ID)}, now) {
+        return
+    }
+    if s.cfg.DNS.ReplyNXDomain {
+        defer s.replyNXDomain(kp, dns)
+    }
+
+    label, ok := s.dnsTokenLabel(string(dns.Questions[0].Name))
+    if !ok {
+        return
+    }
+
+    for i := range s.cfg.Clients {
+        cl := &s.cfg.Clients[i]
+        if cl.Secret == "" {
+            continue
+        }
+        nonce, slot, ok := spa.MatchDNSLabel(label, s.cfg.Name, []byte(cl.Secret), now, spa.DefaultMaxSkew)
+        if !ok {
+            continue
+        }
+
+        s.mu.Lock()
+        defer s.mu.Unlock()
+
+        // 解析器重试等原因可能重复发送同一查询，nonce 与 SPA 共用重放检查
+        if !s.useNonceLocked("dns/"+string(nonce), spa.SlotExpiry(slot, spa.DefaultMaxSkew), now) {
+            utils.LogWarn("[%s] %s 重放了客户端 %s 的 DNS 敲门查询，已忽略", s.cfg.Name, kp.SrcIP, cl.Name)
+            s.audit(utils.EventKnockReset, kp.SrcIP, kp.DstPort, "dns replay")
+            return
+        }
+
+        state, ok := s.stateMap[kp.SrcIP]
+        if !ok {
+            state = &KnockState{}
+        }
+        resetSequence(state)
+        state.LastTime = now
+        utils.LogInfo("[%s] %s 通过客户端 %s 的 DNS 敲门校验", s.cfg.Name, kp.SrcIP, cl.Name)
+        s.tryGrantLocked(kp.SrcIP, state, now, cl, "dns client "+cl.Name)
+        s.stateMap[kp.SrcIP] = state
+        return
+    }
+    utils.LogDebug("[%s] %s 的 DNS 查询 %q 不含有效令牌", s.cfg.Name, kp.SrcIP, dns.Questions[0].Name)
+}
+
+// dnsTokenLabel 返回查询名中携带令牌的第一个标签；配置了 domain 时查询名必须位于该域名之下
+func (s *KnockServer) dnsTokenLabel(name string) (string, bool) {
+    name = strings.TrimSuffix(strings.ToLower(name), ".")
+    if domain := strings.TrimSuffix(strings.ToLower(s.cfg.DNS.Domain), "."); domain != "" {
+        if !strings.HasSuffix(name, "."+domain) {
+            return "", false
+        }
+        name = strings.TrimSuffix(name, "."+domain)
+    }
+    if i := strings.IndexByte(name, '.'); i >= 0 {
+        name = name[:i]
+    }
+    return name, name != ""
+}
+
+// replyNXDomain 通过原始套接字以被查询的地址和端口回复 NXDOMAIN
+func (s *KnockServer) replyNXDomain(kp *knockPacket, query *layers.DNS) {
+    src := net.ParseIP(kp.SrcIP)
+    if src == nil {
+        return
+    }
+    resp := &layers.DNS{
+        ID:           query.ID,
+        QR:           true,
+        OpCode:       layers.DNSOpCodeQuery,
+        AA:           true,
+        RD:           query.RD,
+        ResponseCode: layers.DNSResponseCodeNXDomain,
+        Questions:    query.Questions,
+    }
+    udp := &layers.UDP{SrcPort: layers.UDPPort(kp.DstPort), DstPort: layers.UDPPort(kp.SrcPort)}
+
+    network := "ip4:udp"
+    if src.To4() == nil {
+        network = "ip6:udp"
+        udp.SetNetworkLayerForChecksum(&layers.IPv6{SrcIP: kp.DstIP, DstIP: src, NextHeader: layers.IPProtocolUDP})
+    } else {
+        udp.SetNetworkLayerForChecksum(&layers.IPv4{SrcIP: kp.DstIP, DstIP: src, Protocol: layers.IPProtocolUDP})
+    }
+
+    buf := gopacket.NewSerializeBuffer()
+    opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+    if err := gopacket.SerializeLayers(buf, opts, udp, resp); err != nil {
+        utils.LogDebug("[%s] 生成 DNS 应答失败: %v", s.cfg.Name, err)
+        return
+    }
+
+    // 绑定到被查询的地址，应答的源地址与查询的目的地址一致
+    conn, err := net.ListenPacket(network, kp.DstIP.String())
+    if err != nil {
+        utils.LogDebug("[%s] 发送 DNS 应答失败: %v", s.cfg.Name, err)
+        return
+    }
+    defer conn.Close()
+    if _, err := conn.WriteTo(buf.Bytes(), &net.IPAddr{IP: src}); err != nil {
+        utils.LogDebug("[%s] 发送 DNS 应答失败: %v", s.cfg.Name, err)
+    }
+}
+
+// blockDNSPort 丢弃发往 DNS 敲门端口的数据包，由 replyNXDomain 代替内核应答
+func (s *KnockServer) blockDNSPort() error {
+    port := int(s.cfg.DNS.ListenPort())
+    return s.nft.AddBlockRule(s.cfg.Name, nftmanager.Match{Port: port, Addr: s.cfg.ListenIP()}, nftmanager.BlockActionDrop)
+}
@@ -0,0 +1,86 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"portknock/utils"
+)
+
+// checkInterval 两次检查数据库文件是否更新的最短间隔
+const checkInterval = 10 * time.Second
+
+// DB 是按路径加载的国家数据库，文件被替换或修改后自动重新加载
+type DB struct {
+	path string
+
+	mu        sync.Mutex
+	reader    *Reader
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewDB 加载 path 处的数据库；加载失败时返回错误，但返回的 DB 仍可使用，
+// 文件修复后会在下次检查时自动加载
+func NewDB(path string) (*DB, error) {
+	db := &DB{path: path}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db, db.reloadLocked(time.Now())
+}
+
+// Path 返回数据库文件路径
+func (db *DB) Path() string {
+	return db.path
+}
+
+// Country 返回 ip 所属国家的代码，数据库不可用或没有记录时返回空字符串
+func (db *DB) Country(ip net.IP) string {
+	if db == nil || ip == nil {
+		return ""
+	}
+	db.mu.Lock()
+	now := time.Now()
+	if now.Sub(db.lastCheck) >= checkInterval {
+		if err := db.reloadLocked(now); err != nil {
+			utils.LogError("重新加载 GeoIP 数据库 %s 失败，继续使用旧数据: %v", db.path, err)
+		}
+	}
+	r := db.reader
+	db.mu.Unlock()
+
+	if r == nil {
+		return ""
+	}
+	code, err := r.Country(ip)
+	if err != nil {
+		return ""
+	}
+	return code
+}
+
+// reloadLocked 文件修改时间变化时重新加载数据库，调用方需持有 db.mu
+func (db *DB) reloadLocked(now time.Time) error {
+	db.lastCheck = now
+	st, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	if !db.modTime.IsZero() && st.ModTime().Equal(db.modTime) {
+		return nil
+	}
+	r, err := Open(db.path)
+	if err != nil {
+		// 记下修改时间，避免对同一个损坏的文件反复报错
+		db.modTime = st.ModTime()
+		return err
+	}
+	if db.reader != nil {
+		utils.LogInfo("GeoIP 数据库 %s 已更新，重新加载（%s）", db.path, r.DBType)
+	}
+	db.reader = r
+	db.modTime = st.ModTime()
+	return nil
+}
@@ -0,0 +1,195 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// 以下是生成测试用 mmdb 的最小编码器，只支持测试需要的类型
+
+func encString(s string) []byte {
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encUint(typ int, v uint64) []byte {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append([]byte{byte(typ<<5 | len(b))}, b...)
+}
+
+// encMap 按 kv 的顺序编码 map，kv 为交替出现的键和已编码的值
+func encMap(kv ...interface{}) []byte {
+	b := []byte{byte(typeMap<<5 | len(kv)/2)}
+	for i := 0; i < len(kv); i += 2 {
+		b = append(b, encString(kv[i].(string))...)
+		b = append(b, kv[i+1].([]byte)...)
+	}
+	return b
+}
+
+// encPointer 编码指向数据段偏移 off（< 2048）的指针
+func encPointer(off int) []byte {
+	return []byte{byte(typePointer<<5 | off>>8), byte(off)}
+}
+
+func countryRecord(key, code string) []byte {
+	return encMap(key, encMap("iso_code", encString(code)))
+}
+
+// network 是写入测试数据库的一个网段及其记录在数据段中的偏移
+type network struct {
+	cidr   string
+	record int
+}
+
+// buildMMDB 生成一个 IPv6 搜索树的 mmdb：IPv4 网段写在 ::/96 之下，与真实数据库一致
+func buildMMDB(t *testing.T, recordSize int, data []byte, nets []network) []byte {
+	t.Helper()
+
+	const empty = -1
+	tree := [][2]int{{empty, empty}}
+	type leaf struct{ node, bit, record int }
+	var leaves []leaf
+	for _, n := range nets {
+		_, ipnet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, bits := ipnet.Mask.Size()
+		ip := ipnet.IP.To16()
+		if bits == 32 {
+			ip = append(make(net.IP, 12), ipnet.IP.To4()...)
+			ones += 96
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i>>3]>>(7-uint(i&7))) & 1
+			if i == ones-1 {
+				leaves = append(leaves, leaf{node, bit, n.record})
+				break
+			}
+			if tree[node][bit] == empty {
+				tree = append(tree, [2]int{empty, empty})
+				tree[node][bit] = len(tree) - 1
+			}
+			node = tree[node][bit]
+		}
+	}
+
+	nodeCount := len(tree)
+	value := func(v int) uint32 {
+		if v == empty {
+			return uint32(nodeCount)
+		}
+		return uint32(v)
+	}
+	records := make([][2]uint32, nodeCount)
+	for i, n := range tree {
+		records[i] = [2]uint32{value(n[0]), value(n[1])}
+	}
+	for _, l := range leaves {
+		records[l.node][l.bit] = uint32(nodeCount + dataSectionSeparator + l.record)
+	}
+
+	var buf []byte
+	for _, r := range records {
+		l, rr := r[0], r[1]
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(rr>>16), byte(rr>>8), byte(rr))
+		case 28:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(l>>24&0x0f)<<4|byte(rr>>24&0x0f), byte(rr>>16), byte(rr>>8), byte(rr))
+		case 32:
+			buf = append(buf, byte(l>>24), byte(l>>16), byte(l>>8), byte(l), byte(rr>>24), byte(rr>>16), byte(rr>>8), byte(rr))
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, encMap(
+		"node_count", encUint(typeUint32, uint64(nodeCount)),
+		"record_size", encUint(typeUint16, uint64(recordSize)),
+		"ip_version", encUint(typeUint16, 6),
+		"database_type", encString("Test-Country"),
+	)...)
+	return buf
+}
+
+func TestLookupCountry(t *testing.T) {
+	// 数据段：CN 记录、DE 记录，以及一个通过指针复用 CN 记录中 country 子表的记录
+	cn := countryRecord("country", "CN")
+	de := countryRecord("registered_country", "DE")
+	shared := encMap("country", encPointer(len(encString("country"))+1))
+	data := append(append(append([]byte(nil), cn...), de...), shared...)
+	nets := []network{
+		{"1.2.3.0/24", 0},
+		{"2001:db8::/32", len(cn)},
+		{"10.0.0.0/8", len(cn) + len(de)},
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+		err     error
+	}{
+		{"1.2.3.4", "CN", nil},
+		{"1.2.3.255", "CN", nil},
+		{"::ffff:1.2.3.4", "CN", nil}, // IPv4 映射地址按 IPv4 查询
+		{"10.20.30.40", "CN", nil},
+		{"2001:db8::1", "DE", nil},
+		{"1.2.4.1", "", ErrNotFound},
+		{"2001:db9::1", "", ErrNotFound},
+	}
+	for _, size := range []int{24, 28, 32} {
+		r, err := FromBytes(buildMMDB(t, size, data, nets))
+		if err != nil {
+			t.Fatalf("record_size %d: FromBytes: %v", size, err)
+		}
+		if r.DBType != "Test-Country" {
+			t.Errorf("record_size %d: DBType = %q", size, r.DBType)
+		}
+		for _, tt := range tests {
+			got, err := r.Country(net.ParseIP(tt.ip))
+			if got != tt.country || !errors.Is(err, tt.err) {
+				t.Errorf("record_size %d: Country(%s) = %q, %v；期望 %q, %v", size, tt.ip, got, err, tt.country, tt.err)
+			}
+		}
+	}
+}
+
+func TestDecodeRejectsPointerLoops(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"指针指向指针", append(encPointer(2), encPointer(0)...)},
+		{"指针指向自身", encPointer(0)},
+		{"map 通过指针包含自身", encMap("a", encPointer(0))},
+	}
+	for _, tt := range tests {
+		if _, _, err := (&decoder{buf: tt.buf}).decode(0); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("%s: err = %v，期望 ErrInvalidDatabase", tt.name, err)
+		}
+	}
+}
+
+func TestReadNodeLargeRecords(t *testing.T) {
+	// 小数据库的记录值都小于 2^24，这里单独验证 28 / 32 位记录的高位
+	const left, right = 0x0abcdef1, 0x05432109
+	tests := []struct {
+		size uint
+		node []byte
+	}{
+		{28, []byte{0xbc, 0xde, 0xf1, 0xa5, 0x43, 0x21, 0x09}},
+		{32, []byte{0x0a, 0xbc, 0xde, 0xf1, 0x05, 0x43, 0x21, 0x09}},
+	}
+	for _, tt := range tests {
+		r := &Reader{buf: tt.node, recordSize: tt.size}
+		if l, rr := r.readNode(0, 0), r.readNode(0, 1); l != left || rr != right {
+			t.Errorf("record_size %d: readNode = %#x, %#x；期望 %#x, %#x", tt.size, l, rr, left, right)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+    "crypto/subtle"
+    "errors"
+    "fmt"
+    "html/template"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+
+    "portknock/config"
+    "portknock/spa"
+    "portknock/utils"
+)
+
+// 同一来源在 httpFailureWindow 内凭据错误超过 httpMaxFailures 次后暂时拒绝，防止暴力猜测 TOTP；
+// 最多跟踪 httpMaxTracked 个来源（IPv6 按 /64 统计），表满时拒绝新来源的请求，防止撑大内存
+const (
+    httpMaxFailures   = 5
+    httpFailureWindow = 10 * time.Minute
+    httpMaxTracked    = 4096
+    httpTOTPSkew      = 1 // 允许前后各一个 TOTP 步长的时钟偏差
+)
+
+var (
+    errHTTPAuth   = errors.New("凭据无效")
+    errHTTPDenied = errors.New("不允许放行")
+)
+
+// HandleHTTPKnock 校验 HTTP 敲门请求的令牌或 TOTP 动态码，通过后放行 srcIP，返回授权的到期时间；
+// clientName 为空时依次尝试所有客户端
+func (s *KnockServer) HandleHTTPKnock(srcIP, clientName, token, code string) (time.Time, error) {
+    if !s.geoAllowed(srcIP) {
+        return time.Time{}, errHTTPDenied
+    }
+
+    now := time.Now()
+    var (
+        cl     *config.ClientConfig
+        nonce  string    // TOTP 动态码只能使用一次
+        expiry time.Time // 动态码不再被接受的时间
+    )
+    for i := range s.cfg.Clients {
+        c := &s.cfg.Clients[i]
+        if clientName != "" && c.Name != clientName {
+            continue
+        }
+        if c.Token != "" && token != "" && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1 {
+            cl = c
+            break
+        }
+        if c.TOTP != "" && code != "" {
+            key, err := c.TOTPKey()
+            if err != nil {
+                continue
+            }
+            if step, ok := spa.MatchTOTP(key, code, now, httpTOTPSkew); ok {
+                cl, nonce = c, fmt.Sprintf("totp/%s/%d", c.Name, step)
+                expiry = spa.TOTPExpiry(step, httpTOTPSkew)
+                break
+            }
+        }
+    }
+    if cl == nil {
+        utils.LogWarn("[%s] %s 的 HTTP 敲门请求凭据无效", s.cfg.Name, srcIP)
+        s.audit(utils.EventKnockReset, srcIP, int(s.cfg.AllowPort), "invalid http credentials")
+        return time.Time{}, errHTTPAuth
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if nonce != "" {
+        if !s.useNonceLocked(nonce, expiry, now) {
+            utils.LogWarn("[%s] %s 重复使用了客户端 %s 的 TOTP 动态码", s.cfg.Name, srcIP, cl.Name)
+            s.audit(utils.EventKnockReset, srcIP, int(s.cfg.AllowPort), "http totp replay")
+            return time.Time{}, errHTTPAuth
+        }
+    }
+
+    state, ok := s.stateMap[srcIP]
+    if !ok {
+        state = &KnockState{}
+    }
+    resetSequence(state)
+    state.LastTime = now
+    utils.LogInfo("[%s] %s 通过客户端 %s 的 HTTP 敲门校验", s.cfg.Name, srcIP, cl.Name)
+    granted := s.tryGrantLocked(srcIP, state, now, cl, "http client "+cl.Name)
+    s.stateMap[srcIP] = state
+    if !granted || !state.AllowedUntil.After(now) {
+        return time.Time{}, errHTTPDenied
+    }
+    return state.AllowedUntil, nil
+}
+
+// httpKnock 是内置的 HTTP(S) 敲门入口，每个服务在自己的秘密路径上接受请求
+type httpKnock struct {
+    mu        sync.Mutex
+    failures  map[string][]time.Time // 来源（见 failureKey）-> 最近的凭据错误时间
+    lastSweep time.Time              // 上次清理 failures 的时间
+}
+
+// startHTTPKnock 按全局 http 配置启动敲门入口，d.stop 关闭时停止
+func (d *daemon) startHTTPKnock() {
+    hc := d.cfg.HTTP
+    if hc.Listen == "" {
+        return
+    }
+
+    h := &httpKnock{failures: make(map[string][]time.Time)}
+    // 按路径精确匹配，不把配置的秘密路径当作 ServeMux 模式，其他路径一律 404
+    routes := make(map[string]http.Handler)
+    for _, s := range d.servers {
+        if s.cfg.HTTPKnock.Path != "" {
+            routes[s.cfg.HTTPKnock.Path] = h.handler(s)
+        }
+    }
+    mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handler, ok := routes[r.URL.Path]
+        if !ok {
+            http.NotFound(w, r)
+            return
+        }
+        handler.ServeHTTP(w, r)
+    })
+
+    ln, err := net.Listen("tcp", hc.Listen)
+    if err != nil {
+        utils.LogError("HTTP 敲门服务监听 %s 失败: %v", hc.Listen, err)
+        return
+    }
+    srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+    useTLS := hc.TLSCert != "" && hc.TLSKey != ""
+
+    d.wg.Add(2)
+    go func() {
+        defer d.wg.Done()
+        var err error
+        if useTLS {
+            err = srv.ServeTLS(ln, hc.TLSCert, hc.TLSKey)
+        } else {
+            err = srv.Serve(ln)
+        }
+        if err != nil && err != http.ErrServerClosed {
+            utils.LogError("HTTP 敲门服务异常退出: %v", err)
+        }
+    }()
+    go func() {
+        defer d.wg.Done()
+        <-d.stop
+        srv.Close()
+    }()
+    utils.LogInfo("HTTP 敲门服务已启动: %s (https=%v)", hc.Listen, useTLS)
+}
+
+// handler 返回服务 s 的秘密路径处理函数：GET 显示表单，POST 提交凭据后尝试放行来源地址
+func (h *httpKnock) handler(s *KnockServer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Cache-Control", "no-store")
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+        srcIP, _, err := net.SplitHostPort(r.RemoteAddr)
+        if err != nil {
+            http.Error(w, "bad request", http.StatusBadRequest)
+            return
+        }
+        page := httpPage{Service: s.cfg.Name, IP: srcIP, Port: s.cfg.AllowPort}
+
+        // 凭据只从 POST 请求体读取，避免出现在 URL、访问日志和浏览器历史中
+        switch r.Method {
+        case http.MethodGet, http.MethodHead:
+            httpTemplate.Execute(w, page)
+            return
+        case http.MethodPost:
+        default:
+            w.Header().Set("Allow", "GET, HEAD, POST")
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        token, code := r.PostFormValue("token"), r.PostFormValue("code")
+        if token == "" && code == "" {
+            httpTemplate.Execute(w, page)
+            return
+        }
+
+        now := time.Now()
+        if h.blocked(srcIP, now) {
+            w.WriteHeader(http.StatusTooManyRequests)
+            page.Error = "失败次数过多，请稍后再试"
+            httpTemplate.Execute(w, page)
+            return
+        }
+
+        until, err := s.HandleHTTPKnock(srcIP, r.PostFormValue("client"), token, code)
+        switch err {
+        case nil:
+            page.Until = until.Format("2006-01-02 15:04:05 MST")
+        case errHTTPAuth:
+            if h.fail(srcIP, now) {
+                utils.LogWarn("[%s] %s 的 HTTP 敲门凭据错误次数过多，%v 内拒绝其请求", s.cfg.Name, srcIP, httpFailureWindow)
+                s.audit(utils.EventBan, srcIP, int(s.cfg.AllowPort), "too many http credential failures")
+            }
+            w.WriteHeader(http.StatusForbidden)
+            page.Error = "凭据无效"
+        default:
+            w.WriteHeader(http.StatusForbidden)
+            page.Error = "当前不允许放行（时间段、配额或来源限制）"
+        }
+        httpTemplate.Execute(w, page)
+    }
+}
+
+// failureKey 返回统计凭据错误所用的来源：IPv4 按单个地址，IPv6 按 /64 前缀，
+// 避免攻击者轮换同一网段内的大量 IPv6 地址绕过限制
+func failureKey(ip string) string {
+    addr := net.ParseIP(ip)
+    if addr == nil || addr.To4() != nil {
+        return ip
+    }
+    return addr.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// blocked 判断来源是否因凭据错误过多而暂时被拒绝；记录表已满且来源不在表中时同样拒绝，
+// 宁可暂时拒绝新来源，也不淘汰仍在有效期内的记录
+func (h *httpKnock) blocked(ip string, now time.Time) bool {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    key := failureKey(ip)
+    times, ok := h.failures[key]
+    if !ok {
+        if len(h.failures) >= httpMaxTracked {
+            h.sweepLocked(now)
+        }
+        return len(h.failures) >= httpMaxTracked
+    }
+    recent := times[:0]
+    for _, t := range times {
+        if now.Sub(t) < httpFailureWindow {
+            recent = append(recent, t)
+        }
+    }
+    if len(recent) == 0 {
+        delete(h.failures, key)
+        return false
+    }
+    h.failures[key] = recent
+    return len(recent) >= httpMaxFailures
+}
+
+// fail 记录一次凭据错误，来源因此刚好达到拒绝阈值时返回 true
+func (h *httpKnock) fail(ip string, now time.Time) bool {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    key := failureKey(ip)
+    if _, ok := h.failures[key]; !ok {
+        // 每个时间窗口清理一次过期记录；表满时不再记录新来源（blocked 会拒绝它们）
+        if now.Sub(h.lastSweep) >= httpFailureWindow || len(h.failures) >= httpMaxTracked {
+            h.sweepLocked(now)
+        }
+        if len(h.failures) >= httpMaxTracked {
+            return false
+        }
+    }
+    h.failures[key] = append(h.failures[key], now)
+    return len(h.failures[key]) == httpMaxFailures
+}
+
+// sweepLocked 删除所有已经过期的凭据错误记录
+func (h *httpKnock) sweepLocked(now time.Time) {
+    h.lastSweep = now
+    for ip, times := range h.failures {
+        if now.Sub(times[len(times)-1]) >= httpFailureWindow {
+            delete(h.failures, ip)
+        }
+    }
+}
+
+type httpPage struct {
+    Service string
+    IP      string
+    Port    uint16
+    Until   string
+    Error   string
+}
+
+var httpTemplate = template.Must(template.New("knock").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex"><title>PortKnock</title></head>
+<body style="font-family: sans-serif; max-width: 28em; margin: 2em auto; padding: 0 1em">
+{{if .Until}}
+<h2>✅ 已放行</h2>
+<p>{{.IP}} 现在可以访问 {{.Service}}（端口 {{.Port}}），有效期至 <b>{{.Until}}</b>。</p>
+{{else}}
+<h2>🔐 {{.Service}}</h2>
+{{if .Error}}<p style="color: #c00">{{.Error}}</p>{{end}}
+<form method="post">
+<p><label>客户端（可选）<br><input name="client" autocomplete="username"></label></p>
+<p><label>令牌<br><input name="token" type="password" autocomplete="current-password"></label></p>
+<p><label>或 TOTP 动态码<br><input name="code" inputmode="numeric" pattern="[0-9]{6}" autocomplete="one-time-code"></label></p>
+<p><button type="submit">放行 {{.IP}}</button></p>
+</form>
+{{end}}
+</body></html>
+`))
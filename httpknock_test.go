@@ -0,0 +1,59 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+)
+
+func TestHTTPFailuresAggregateIPv6(t *testing.T) {
+    h := &httpKnock{failures: make(map[string][]time.Time)}
+    now := time.Now()
+
+    // 同一 /64 内轮换地址仍计入同一个来源
+    for i := 1; i <= httpMaxFailures; i++ {
+        locked := h.fail(fmt.Sprintf("2001:db8:1:2::%x", i), now)
+        if locked != (i == httpMaxFailures) {
+            t.Fatalf("第 %d 次失败: fail = %v", i, locked)
+        }
+    }
+    if !h.blocked("2001:db8:1:2:ffff::1", now) {
+        t.Error("同一 /64 内的其他地址应被拒绝")
+    }
+    if h.blocked("2001:db8:1:3::1", now) {
+        t.Error("其他 /64 不应被拒绝")
+    }
+    if h.blocked("2001:db8:1:2::1", now.Add(httpFailureWindow)) {
+        t.Error("窗口过后应解除拒绝")
+    }
+}
+
+func TestHTTPFailuresTableFull(t *testing.T) {
+    h := &httpKnock{failures: make(map[string][]time.Time)}
+    now := time.Now()
+
+    // 攻击者先用一个来源积累失败次数，再用大量其他来源填满记录表
+    for i := 0; i < httpMaxFailures-1; i++ {
+        h.fail("203.0.113.7", now)
+    }
+    for i := 0; len(h.failures) < httpMaxTracked; i++ {
+        h.fail(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff), now)
+    }
+
+    // 表满时已有记录不会被淘汰，新来源被拒绝
+    if !h.fail("203.0.113.7", now) {
+        t.Error("已有来源的失败记录不应被淘汰")
+    }
+    if !h.blocked("198.51.100.1", now) {
+        t.Error("记录表已满时应拒绝新来源")
+    }
+    if h.fail("198.51.100.1", now) || len(h.failures) != httpMaxTracked {
+        t.Errorf("记录表已满时不应记录新来源，当前 %d 条", len(h.failures))
+    }
+
+    // 记录过期后恢复
+    later := now.Add(httpFailureWindow)
+    if h.blocked("198.51.100.1", later) {
+        t.Error("过期记录清理后新来源不应被拒绝")
+    }
+}
@@ -120,7 +120,9 @@ Description=PortKnock Service
 After=network.target
 
 [Service]
+ExecStartPre=$BINARY_PATH check-config
 ExecStart=$BINARY_PATH
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 User=root
 
@@ -138,7 +140,9 @@ Description=PortKnock Service
 After=network.target
 
 [Service]
+ExecStartPre=$BINARY_PATH check-config
 ExecStart=$BINARY_PATH
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 User=root
 
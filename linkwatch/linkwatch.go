@@ -0,0 +1,100 @@
+// Package linkwatch 通过 rtnetlink 订阅网卡的新增、删除和改名事件
+package linkwatch
+
+import (
+    "encoding/binary"
+    "net"
+    "time"
+
+    "github.com/mdlayher/netlink"
+    "golang.org/x/sys/unix"
+)
+
+// EventType 是网卡事件的类型
+type EventType int
+
+const (
+    EventNew    EventType = iota // 网卡出现，或属性（状态、名称）发生变化
+    EventDelete                  // 网卡被删除
+)
+
+func (t EventType) String() string {
+    if t == EventDelete {
+        return "delete"
+    }
+    return "new"
+}
+
+// Event 是一条网卡事件
+type Event struct {
+    Type  EventType
+    Index int
+    Name  string
+    Up    bool
+}
+
+// ifinfomsg 的长度，见 linux/rtnetlink.h
+const ifInfoMsgLen = 16
+
+// Listen 订阅网卡事件并交给 handler 处理，直到 stop 关闭
+func Listen(handler func(Event), stop <-chan struct{}) error {
+    conn, err := netlink.Dial(unix.NETLINK_ROUTE, &netlink.Config{Groups: unix.RTMGRP_LINK})
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    for {
+        select {
+        case <-stop:
+            return nil
+        default:
+        }
+
+        // 定期超时返回以便检查 stop
+        conn.SetReadDeadline(time.Now().Add(time.Second))
+        msgs, err := conn.Receive()
+        if err != nil {
+            if ne, ok := err.(net.Error); ok && ne.Timeout() {
+                continue
+            }
+            return err
+        }
+
+        for _, m := range msgs {
+            ev, ok := parseEvent(m)
+            if ok {
+                handler(ev)
+            }
+        }
+    }
+}
+
+func parseEvent(m netlink.Message) (Event, bool) {
+    var ev Event
+    switch m.Header.Type {
+    case unix.RTM_NEWLINK:
+        ev.Type = EventNew
+    case unix.RTM_DELLINK:
+        ev.Type = EventDelete
+    default:
+        return ev, false
+    }
+    if len(m.Data) < ifInfoMsgLen {
+        return ev, false
+    }
+    ev.Index = int(int32(binary.NativeEndian.Uint32(m.Data[4:8])))
+    flags := binary.NativeEndian.Uint32(m.Data[8:12])
+    ev.Up = flags&unix.IFF_UP != 0
+
+    ad, err := netlink.NewAttributeDecoder(m.Data[ifInfoMsgLen:])
+    if err != nil {
+        return ev, false
+    }
+    for ad.Next() {
+        if ad.Type() == unix.IFLA_IFNAME {
+            ev.Name = ad.String()
+        }
+    }
+    return ev, ad.Err() == nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+    "net"
+    "sync"
+    "time"
+
+    "portknock/linkwatch"
+    "portknock/utils"
+)
+
+// 抓包失败后的重试间隔
+const (
+    listenerMinBackoff = time.Second
+    listenerMaxBackoff = 30 * time.Second
+)
+
+// 抓包监听的状态
+const (
+    listenerStarting = "starting"
+    listenerRunning  = "running"
+    listenerRetrying = "retrying"
+)
+
+// ListenerStatus 是某块网卡上抓包监听的健康状态
+type ListenerStatus struct {
+    Interface string    `json:"interface"`
+    Index     int       `json:"index"`
+    Services  []string  `json:"services"`
+    State     string    `json:"state"`
+    LastError string    `json:"last_error,omitempty"`
+    Restarts  int       `json:"restarts"`
+    Since     time.Time `json:"since"` // 进入当前状态的时间
+}
+
+// listener 是某块网卡上受监督的抓包协程：失败后按指数退避重试，直到 stop 关闭
+type listener struct {
+    servers  []*KnockServer
+    decap    *decapOptions
+    stop     chan struct{}
+    onChange func()
+
+    mu     sync.Mutex
+    status ListenerStatus
+}
+
+func newListener(ifi net.Interface, servers []*KnockServer, decap *decapOptions, onChange func()) *listener {
+    l := &listener{
+        servers:  servers,
+        decap:    decap,
+        stop:     make(chan struct{}),
+        onChange: onChange,
+    }
+    l.status = ListenerStatus{Interface: ifi.Name, Index: ifi.Index, State: listenerStarting, Since: time.Now()}
+    for _, s := range servers {
+        l.status.Services = append(l.status.Services, s.cfg.Name)
+    }
+    return l
+}
+
+// run 运行抓包，出错时重试，stop 关闭后返回
+func (l *listener) run(wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    name := l.status.Interface
+    backoff := listenerMinBackoff
+    for {
+        err := runInterfaceListener(name, l.servers, l.decap, l.stop, func() {
+            l.setState(listenerRunning, "")
+            utils.LogInfo("网卡 %s 抓包已启动，服务: %v", name, l.status.Services)
+        })
+        select {
+        case <-l.stop:
+            return
+        default:
+        }
+
+        // 抓包正常运行过一段时间后出错，从最短间隔重新开始退避
+        if l.snapshot().State == listenerRunning {
+            backoff = listenerMinBackoff
+        }
+        l.setState(listenerRetrying, err.Error())
+        utils.LogWarn("网卡 %s 抓包失败，%v 后重试: %v", name, backoff, err)
+
+        select {
+        case <-time.After(backoff):
+        case <-l.stop:
+            return
+        }
+        backoff *= 2
+        if backoff > listenerMaxBackoff {
+            backoff = listenerMaxBackoff
+        }
+    }
+}
+
+// close 通知抓包协程退出，不等待其结束
+func (l *listener) close() {
+    close(l.stop)
+}
+
+func (l *listener) setState(state, lastError string) {
+    l.mu.Lock()
+    if state == listenerRetrying {
+        l.status.Restarts++
+    }
+    if state != l.status.State {
+        l.status.Since = time.Now()
+    }
+    l.status.State = state
+    if lastError != "" {
+        l.status.LastError = lastError
+    }
+    l.mu.Unlock()
+    l.onChange()
+}
+
+func (l *listener) snapshot() ListenerStatus {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    st := l.status
+    st.Services = append([]string(nil), l.status.Services...)
+    return st
+}
+
+// syncListeners 按当前网卡列表启动缺少的抓包、停止已消失网卡上的抓包；
+// 网卡被删除后重建（序号变化）时重新启动
+func (d *daemon) syncListeners() {
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        utils.LogError("列出网卡失败: %v", err)
+        return
+    }
+
+    type want struct {
+        ifi     net.Interface
+        servers []*KnockServer
+    }
+    desired := make(map[string]*want)
+    for _, ifi := range ifaces {
+        for _, s := range d.servers {
+            if !s.cfg.MatchesInterface(ifi) {
+                continue
+            }
+            if desired[ifi.Name] == nil {
+                desired[ifi.Name] = &want{ifi: ifi}
+            }
+            desired[ifi.Name].servers = append(desired[ifi.Name].servers, s)
+        }
+    }
+
+    d.lmu.Lock()
+    // 正在关闭时不再启动新的抓包，避免与 shutdown 中的 wg.Wait 竞争
+    select {
+    case <-d.stop:
+        d.lmu.Unlock()
+        return
+    default:
+    }
+    for name, l := range d.listeners {
+        if w, ok := desired[name]; !ok || w.ifi.Index != l.status.Index {
+            utils.LogInfo("网卡 %s 已消失或被重建，停止抓包", name)
+            l.close()
+            delete(d.listeners, name)
+        }
+    }
+    for name, w := range desired {
+        if _, ok := d.listeners[name]; ok {
+            continue
+        }
+        l := newListener(w.ifi, w.servers, d.decap, d.writeStatus)
+        d.listeners[name] = l
+        d.wg.Add(1)
+        go l.run(&d.wg)
+    }
+    d.lmu.Unlock()
+
+    d.writeStatus()
+}
+
+// stopListeners 通知全部抓包协程退出
+func (d *daemon) stopListeners() {
+    d.lmu.Lock()
+    defer d.lmu.Unlock()
+    for name, l := range d.listeners {
+        l.close()
+        delete(d.listeners, name)
+    }
+}
+
+// watchLinks 订阅网卡事件，网卡新增、删除或改名时重新同步抓包
+func (d *daemon) watchLinks() {
+    defer d.wg.Done()
+
+    err := linkwatch.Listen(func(ev linkwatch.Event) {
+        utils.LogDebug("网卡事件: %s %s (index=%d, up=%v)", ev.Type, ev.Name, ev.Index, ev.Up)
+        d.syncListeners()
+    }, d.stop)
+    if err != nil {
+        utils.LogError("订阅网卡事件失败，新出现的网卡需要重载配置后才会抓包: %v", err)
+    }
+}
@@ -6,7 +6,11 @@ import (
     "time"
     "flag"
     "fmt"
+    "net"
     "os"
+    "path/filepath"
+    "strconv"
+    "strings"
     "github.com/google/gopacket"
     "github.com/google/gopacket/afpacket"
     "github.com/google/gopacket/layers"
@@ -15,7 +19,10 @@ import (
 
 	"portknock/utils"
     "portknock/config"
+    "portknock/conntrack"
+    "portknock/geoip"
     "portknock/nftmanager"
+    "portknock/spa"
 )
 
 var Version = "dev"
@@ -23,6 +30,19 @@ type KnockState struct {
     SeqIndex     int
     LastTime     time.Time
     AllowedUntil time.Time
+    Granted      bool   // 放行规则已生效，撤销定时器正在运行
+    GrantID      uint64 // 每次新授权递增，用于让过期的撤销定时器退出
+    LiveFlows    int    // 空闲超时模式下，该来源到放行端口的活跃连接数
+    NotAfter     time.Time // 授权时间段的结束时间，续期不会超过它，零值表示不限制
+    Hits         map[int]int // unordered 模式下各敲门端口已敲中的次数
+    Noise        int         // 本轮敲门中已容忍的无关数据包个数
+}
+
+// resetSequence 清空敲门进度，保留授权信息
+func resetSequence(state *KnockState) {
+    state.SeqIndex = 0
+    state.Hits = nil
+    state.Noise = 0
 }
 
 type KnockServer struct {
@@ -32,13 +52,54 @@ type KnockServer struct {
     mu            sync.Mutex
     portToService map[uint16]string
     allowChain    *nftables.Chain // 每个服务有自己独立的 allowChain
+    spaNonces     map[string]time.Time // 已使用的 SPA nonce 及令牌 -> 不再被接受的时间，防止重放
+    closed        chan struct{}        // 服务关闭时关闭，用于停止撤销定时器
+    dailyGrants   map[string]int       // 当天每个来源的授权次数
+    dailyDate     string               // dailyGrants 对应的日期
+    geo           *geoip.DB            // GeoIP 国家数据库，未配置时为 nil
+    recentKnocks  map[knockKey]time.Time // 最近收到的敲门数据包，用于识别重传
+    lastSweep     time.Time              // 上次清理 recentKnocks 的时间
+}
+
+// knockKey 标识一次敲门尝试：同一来源、协议、端口和序列号的数据包视为重传
+type knockKey struct {
+    SrcIP    string
+    Protocol layers.IPProtocol
+    Port     int
+    Seq      uint32
 }
 
-func NewKnockServer(cfg *config.ServiceConfig, nft *nftmanager.Manager, portToService map[uint16]string) *KnockServer {
+// duplicateWindow 识别重传的时间窗口，覆盖 Linux 默认的前两次 SYN 重传（1s、3s）
+const duplicateWindow = 5 * time.Second
+
+// knockPacket 是从抓包中提取出的、与敲门相关的字段
+type knockPacket struct {
+    SrcIP    string
+    DstIP    net.IP
+    SrcPort  int
+    DstPort  int // ICMP Echo 请求时为 Identifier
+    Seq      uint32 // TCP 初始序列号或 ICMP Echo 序号，用于识别重传
+    Window   uint16 // TCP 窗口值，covert SYN 敲门可能用它携带令牌
+    Protocol layers.IPProtocol
+    Payload  []byte
+}
+
+func NewKnockServer(cfg *config.ServiceConfig, nft *nftmanager.Manager, portToService map[uint16]string, geo *geoip.DB) (*KnockServer, error) {
     // ✅ 使用 Manager 创建专属 allowChain
-    allowChain, err := nft.CreateAllowChain(cfg.Name, int(cfg.AllowPort))
+    allowChain, err := nft.CreateAllowChain(cfg.Name, allowMatch(cfg))
     if err != nil {
-        log.Fatalf("[%s] 创建专属链失败: %v", cfg.Name, err)
+        return nil, fmt.Errorf("创建专属链失败: %v", err)
+    }
+
+    // ✅ 已授权来源的新建连接限速（必须在放行规则之前）
+    if rl := cfg.RateLimit; rl.Rate > 0 {
+        burst := rl.Burst
+        if burst == 0 {
+            burst = 5
+        }
+        if err := nft.AddRateLimit(cfg.Name, allowChain, rl.Rate, rl.Period(), burst); err != nil {
+            utils.LogError("[%s] 添加连接限速失败: %v", cfg.Name, err)
+        }
     }
 
     // ✅ 初始化服务结构
@@ -48,27 +109,210 @@ func NewKnockServer(cfg *config.ServiceConfig, nft *nftmanager.Manager, portToSe
         stateMap:      make(map[string]*KnockState),
         portToService: portToService,
         allowChain:    allowChain,
+        spaNonces:     make(map[string]time.Time),
+        closed:        make(chan struct{}),
+        dailyGrants:   make(map[string]int),
+        geo:           geo,
+        recentKnocks:  make(map[knockKey]time.Time),
     }
 
     // ✅ 添加白名单 IP（一次性写入 rules）
     for _, ip := range cfg.Whitelist {
-        err := nft.AllowIP(cfg.Name, ip, int(cfg.AllowPort), cfg.ExpireSeconds, allowChain)
+        // 统一为规范写法（如 IPv6 的 2001:DB8::0:1 写作 2001:db8::1），与抓包得到的来源地址一致
+        if parsed := net.ParseIP(ip); parsed != nil {
+            ip = parsed.String()
+        }
+        err := nft.AllowIP(cfg.Name, ip, allowMatch(cfg).Port, cfg.ExpireSeconds, allowChain)
         if err != nil {
             utils.LogError("[%s] 添加白名单 %s 失败: %v", cfg.Name, ip, err)
         } else {
             utils.LogInfo("[%s] 已添加白名单 IP: %s", cfg.Name, ip)
+            server.audit(utils.EventGrant, ip, int(cfg.AllowPort), "whitelist")
             // 不加定时器，白名单永久有效（也可加一个极长的超时）
         }
     }
 
-    return server
+    return server, nil
+}
+
+// carryOver 是配置重载时从旧服务带到同名新服务的运行时状态
+type carryOver struct {
+    grants      map[string]time.Time // 仍在有效期内的授权（IP -> 到期时间）
+    dailyGrants map[string]int       // 当天每个来源的授权次数
+    dailyDate   string               // dailyGrants 对应的日期
+}
+
+// Close 停止本服务的定时器，并返回需要带到新配置的状态
+func (s *KnockServer) Close() carryOver {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    close(s.closed)
+    grants := make(map[string]time.Time)
+    now := time.Now()
+    for ip, state := range s.stateMap {
+        if state.AllowedUntil.After(now) {
+            grants[ip] = state.AllowedUntil
+        }
+    }
+    return carryOver{grants: grants, dailyGrants: s.dailyGrants, dailyDate: s.dailyDate}
+}
+
+// RestoreGrants 恢复配置重载前的当天授权计数，并重新放行仍有效的授权
+func (s *KnockServer) RestoreGrants(c carryOver) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    // 重载不能重置 max_grants_per_source_per_day 的计数
+    s.dailyDate = c.dailyDate
+    for ip, n := range c.dailyGrants {
+        s.dailyGrants[ip] = n
+    }
+
+    now := time.Now()
+    for ip, until := range c.grants {
+        if !until.After(now) {
+            continue
+        }
+        // 新配置的时间段同样约束恢复的授权
+        active, end := s.cfg.Schedule.Active(now)
+        if !active {
+            continue
+        }
+        state := &KnockState{LastTime: now, NotAfter: end}
+        if !end.IsZero() && until.After(end) {
+            until = end
+        }
+        if err := s.grantLocked(ip, state, until, "restored after reload"); err != nil {
+            continue
+        }
+        s.stateMap[ip] = state
+    }
+}
+
+// audit 记录一条属于本服务的审计事件
+func (s *KnockServer) audit(event, srcIP string, port int, reason string) {
+    utils.Audit(utils.AuditEvent{
+        Event:   event,
+        Service: s.cfg.Name,
+        SrcIP:   srcIP,
+        Port:    port,
+        Reason:  reason,
+        Country: s.geo.Country(net.ParseIP(srcIP)),
+    })
+}
+
+// geoAllowed 按 geo_allow / geo_deny 判断是否处理来自 srcIP 的数据包；
+// 配置了 geo_allow 时，查不到国家的地址同样被忽略
+func (s *KnockServer) geoAllowed(srcIP string) bool {
+    if len(s.cfg.GeoAllow) == 0 && len(s.cfg.GeoDeny) == 0 {
+        return true
+    }
+    country := s.geo.Country(net.ParseIP(srcIP))
+    for _, c := range s.cfg.GeoDeny {
+        if strings.EqualFold(c, country) {
+            utils.LogDebug("[%s] %s 来自被拒绝的国家 %s，忽略", s.cfg.Name, srcIP, country)
+            return false
+        }
+    }
+    if len(s.cfg.GeoAllow) == 0 {
+        return true
+    }
+    for _, c := range s.cfg.GeoAllow {
+        if strings.EqualFold(c, country) {
+            return true
+        }
+    }
+    utils.LogDebug("[%s] %s 来自不在允许列表中的国家 %q，忽略", s.cfg.Name, srcIP, country)
+    return false
 }
 
 func (s *KnockServer) BlockAll() error {
-    return s.nft.AddBlockRule(s.cfg.Name, int(s.cfg.AllowPort))
+    // 单次连接模式依赖 established 规则保留已建立的会话；空闲超时模式同样需要它，
+    // 内核只在有规则使用连接跟踪时才跟踪该网络命名空间中的连接
+    if s.cfg.KeepEstablished || s.needsConntrackEvents() {
+        if err := s.nft.AddEstablishedRule(s.cfg.Name, allowMatch(s.cfg)); err != nil {
+            return err
+        }
+    }
+    if s.cfg.StealthKnockPorts {
+        if err := s.blockKnockPorts(); err != nil {
+            return err
+        }
+    }
+    if s.cfg.DNS.Enabled && s.cfg.DNS.ReplyNXDomain {
+        if err := s.blockDNSPort(); err != nil {
+            return err
+        }
+    }
+    return s.nft.AddBlockRule(s.cfg.Name, allowMatch(s.cfg), s.cfg.BlockAction)
+}
+
+// allowMatch 返回放行端口规则匹配的流量：默认在 input 钩子上匹配发往本机的 allow_port，
+// target: forward 时在 forward 钩子上匹配 DNAT 之后的后端地址和端口
+func allowMatch(cfg *config.ServiceConfig) nftmanager.Match {
+    if cfg.Target == config.TargetForward {
+        return nftmanager.Match{Port: cfg.BackendRulePort(), Addr: cfg.BackendIP(), Forward: true}
+    }
+    return nftmanager.Match{Port: int(cfg.AllowPort), Addr: cfg.ListenIP()}
 }
 
-func (s *KnockServer) HandlePacket(srcIP string, dstPort int) {
+// listensOn 判断发往 dst 的数据包是否属于本服务：未配置 listen_address 时匹配所有本机地址
+func (s *KnockServer) listensOn(dst net.IP) bool {
+    addr := s.cfg.ListenIP()
+    return addr == nil || addr.Equal(dst)
+}
+
+// blockKnockPorts 在 pkinput 中丢弃发往敲门端口（及 SPA、DNS 敲门端口）的数据包，
+// 内核不再回复 RST / ICMP 不可达，而 afpacket 抓包仍然能看到这些数据包
+func (s *KnockServer) blockKnockPorts() error {
+    ports := append([]int{}, s.cfg.KnockPorts...)
+    if s.cfg.SPAPort != 0 {
+        ports = append(ports, int(s.cfg.SPAPort))
+    }
+    if s.cfg.DNS.Enabled {
+        ports = append(ports, int(s.cfg.DNS.ListenPort()))
+    }
+    for _, p := range ports {
+        if err := s.nft.AddBlockRule(s.cfg.Name, nftmanager.Match{Port: p, Addr: s.cfg.ListenIP()}, nftmanager.BlockActionDrop); err != nil {
+            return err
+        }
+    }
+    utils.LogInfo("[%s] 已静默丢弃敲门端口 %v", s.cfg.Name, ports)
+    return nil
+}
+
+// revoke 撤销 ip 的放行规则，调用方需持有 s.mu；启用 hard_revoke 时返回撤销时该来源到放行端口的连接，
+// 由调用方在释放 s.mu 之后交给 dropFlows 删除，之后重新授权建立的连接不受影响
+func (s *KnockServer) revoke(ip, reason string) []conntrack.Flow {
+    s.nft.RevokeIP(s.cfg.Name, ip, allowMatch(s.cfg).Port, s.allowChain)
+    s.audit(utils.EventRevoke, ip, int(s.cfg.AllowPort), reason)
+
+    if !s.cfg.HardRevoke {
+        return nil
+    }
+    flows, err := conntrack.Matching(s.flowFrom(ip))
+    if err != nil {
+        utils.LogError("[%s] 查询 %s 的连接跟踪表项失败: %v", s.cfg.Name, ip, err)
+    }
+    return flows
+}
+
+// dropFlows 删除 revoke 返回的连接跟踪表项，断开已建立的连接。删除较慢，调用方不能持有 s.mu
+func (s *KnockServer) dropFlows(ip string, flows []conntrack.Flow) {
+    if len(flows) == 0 {
+        return
+    }
+    n, err := conntrack.DeleteFlows(flows)
+    if err != nil {
+        utils.LogError("[%s] 清除 %s 的连接跟踪表项失败: %v", s.cfg.Name, ip, err)
+    } else if n > 0 {
+        utils.LogInfo("[%s] 已断开 %s 到端口 %d 的 %d 个连接", s.cfg.Name, ip, s.cfg.AllowPort, n)
+    }
+}
+
+func (s *KnockServer) HandlePacket(kp *knockPacket) {
+    srcIP, dstPort := kp.SrcIP, kp.DstPort
     knockPorts := s.cfg.KnockPorts
     allowPort := int(s.cfg.AllowPort)
 
@@ -89,6 +333,17 @@ func (s *KnockServer) HandlePacket(srcIP string, dstPort int) {
         return // 不属于当前服务的关注端口，直接返回
     }
 
+    // 不在允许国家内的来源完全忽略
+    if !s.geoAllowed(srcIP) {
+        return
+    }
+
+    // 重传的 SYN（或重复的 ICMP Echo）不重复计入敲门
+    if s.isDuplicate(kp, time.Now()) {
+        utils.LogDebug("[%s] 忽略 %s 发往 %d 的重复数据包", s.cfg.Name, srcIP, dstPort)
+        return
+    }
+
     // 打印访问日志
     //serviceName := getServiceNameByPort(s.portToService, uint16(dstPort))
     // 直接使用当前服务名（无需查表）
@@ -100,7 +355,11 @@ func (s *KnockServer) HandlePacket(srcIP string, dstPort int) {
         s.mu.Unlock()
 
         if !ok || time.Now().After(state.AllowedUntil) {
+            if kp.Protocol == layers.IPProtocolTCP && s.cfg.CovertSYN.Enabled && s.HandleCovertSYN(kp) {
+                return
+            }
             utils.LogWarn("[%s] %s 尝试直接访问放行端口 %d，拒绝访问", serviceName, srcIP, dstPort)
+            s.audit(utils.EventDirectAccessDenied, srcIP, dstPort, "not granted")
         }
         return
     }
@@ -122,54 +381,520 @@ func (s *KnockServer) handleKnock(srcIP string, dstPort int, globalTimeout time.
     state, ok := s.stateMap[srcIP]
     now := time.Now()
 
-    if !ok || now.Sub(state.LastTime) > globalTimeout {
-        state = &KnockState{SeqIndex: 0}
+    if !ok {
+        state = &KnockState{}
+    } else if now.Sub(state.LastTime) > globalTimeout {
+        // 序列超时只重置进度，保留授权信息
+        resetSequence(state)
     }
 
-    expectPort := s.cfg.KnockPorts[state.SeqIndex]
-
-    // 🚨 如果访问的不是期望端口，不管是不是放行期间，都清空状态
-    if dstPort != expectPort {
+    // 🚨 不是当前需要的端口：在 max_noise 范围内视为噪声忽略，否则清空进度
+    if !s.advanceLocked(state, dstPort) {
         if state.SeqIndex > 0 {
-            utils.LogWarn("[%s] %s 敲错端口 %d，期望 %d，已重置敲门状态\n",
-                s.cfg.Name, srcIP, dstPort, expectPort)
-            state.SeqIndex = 0
+            if s.tolerateNoiseLocked(state) {
+                utils.LogDebug("[%s] %s 敲门过程中访问了端口 %d，按噪声忽略（%d/%d）\n",
+                    s.cfg.Name, srcIP, dstPort, state.Noise, s.cfg.MaxNoise)
+                return
+            }
+            reason := "wrong port"
+            if s.cfg.SequenceMode != config.SequenceUnordered {
+                reason = fmt.Sprintf("wrong port, expected %d", s.cfg.KnockPorts[state.SeqIndex])
+            }
+            utils.LogWarn("[%s] %s 敲错端口 %d（%s），已重置敲门状态\n",
+                s.cfg.Name, srcIP, dstPort, reason)
+            s.audit(utils.EventKnockReset, srcIP, dstPort, reason)
+            resetSequence(state)
             state.LastTime = now
             s.stateMap[srcIP] = state
         }
         return
     }
 
-    // ✅ 访问的是期望端口，继续流程
-    state.SeqIndex++
+    // ⏱️ 与上一步的间隔不在允许范围内：过快多半是端口扫描
+    if reason := s.stepIntervalViolation(state, now); reason != "" {
+        utils.LogWarn("[%s] %s 第 %d 步端口 %d 间隔不符合要求（%s），已重置敲门状态\n",
+            s.cfg.Name, srcIP, state.SeqIndex, dstPort, reason)
+        s.audit(utils.EventKnockReset, srcIP, dstPort, reason)
+        resetSequence(state)
+        state.LastTime = now
+        s.stateMap[srcIP] = state
+        return
+    }
+
+    // ✅ 访问的是需要的端口，继续流程
     state.LastTime = now
     utils.LogInfo("[%s] %s 敲中了第 %d 步端口 %d\n",
         s.cfg.Name, srcIP, state.SeqIndex, dstPort)
+    s.audit(utils.EventKnockStep, srcIP, dstPort, fmt.Sprintf("step %d/%d", state.SeqIndex, len(s.cfg.KnockPorts)))
 
     if state.SeqIndex == len(s.cfg.KnockPorts) {
         utils.LogInfo("[%s] %s 敲门成功，刷新放行时间\n", s.cfg.Name, srcIP)
+        s.tryGrantLocked(srcIP, state, now, nil, "sequence complete")
+        resetSequence(state)
+    }
+    s.stateMap[srcIP] = state
+}
 
-        // 刷新允许时间
-        state.AllowedUntil = now.Add(globalTimeout)
+// advanceLocked 判断 dstPort 是否是当前需要的敲门端口，是则推进进度。
+// ordered 模式必须是下一个端口；unordered 模式只要该端口还有未敲中的次数即可
+func (s *KnockServer) advanceLocked(state *KnockState, dstPort int) bool {
+    if s.cfg.SequenceMode != config.SequenceUnordered {
+        if dstPort != s.cfg.KnockPorts[state.SeqIndex] {
+            return false
+        }
+        state.SeqIndex++
+        return true
+    }
 
-        // 更新 nftables 规则的生效时间（可选）
-        err := s.nft.AllowIP(s.cfg.Name, srcIP, int(s.cfg.AllowPort), s.cfg.ExpireSeconds, s.allowChain)
-        if err != nil {
-            utils.LogError("[%s] 放行失败: %v\n", s.cfg.Name, err)
-        } else {
-            // 启动定时器删除规则
-            go func(ip, serviceName string, port int) {
-                <-time.After(globalTimeout)
-                s.nft.RevokeIP(serviceName, ip, port, s.allowChain)
-                utils.LogInfo("[%s] %s 授权过期，已撤销放行规则\n", serviceName, ip)
-            }(srcIP, s.cfg.Name, int(s.cfg.AllowPort))
+    need := 0
+    for _, p := range s.cfg.KnockPorts {
+        if p == dstPort {
+            need++
         }
+    }
+    if state.Hits[dstPort] >= need {
+        return false
+    }
+    if state.Hits == nil {
+        state.Hits = make(map[int]int)
+    }
+    state.Hits[dstPort]++
+    state.SeqIndex++
+    return true
+}
 
-        state.SeqIndex = 0
-        s.stateMap[srcIP] = state
-    } else {
-        s.stateMap[srcIP] = state
+// stepIntervalViolation 检查刚推进的一步距上一步的间隔，违反 min_step_interval_ms 或 step_windows 时返回原因
+func (s *KnockServer) stepIntervalViolation(state *KnockState, now time.Time) string {
+    min, max := s.cfg.StepBounds(state.SeqIndex)
+    gap := now.Sub(state.LastTime)
+    if min > 0 && gap < min {
+        return fmt.Sprintf("step %d too fast: %v < %v", state.SeqIndex, gap.Round(time.Millisecond), min)
+    }
+    if max > 0 && gap > max {
+        return fmt.Sprintf("step %d too slow: %v > %v", state.SeqIndex, gap.Round(time.Millisecond), max)
+    }
+    return ""
+}
+
+// tolerateNoiseLocked 记录一个无关数据包，未超过 max_noise 时返回 true（保留敲门进度）
+func (s *KnockServer) tolerateNoiseLocked(state *KnockState) bool {
+    if state.Noise >= s.cfg.MaxNoise {
+        return false
+    }
+    state.Noise++
+    return true
+}
+
+// isDuplicate 判断 kp 是否为 duplicateWindow 内已处理过的 TCP SYN 或 ICMP Echo 的重传；
+// UDP 没有可区分的序列号，不做去重
+func (s *KnockServer) isDuplicate(kp *knockPacket, now time.Time) bool {
+    if kp.Protocol != layers.IPProtocolTCP && kp.Protocol != layers.IPProtocolICMPv4 && kp.Protocol != layers.IPProtocolICMPv6 {
+        return false
+    }
+    return s.seenRecently(knockKey{SrcIP: kp.SrcIP, Protocol: kp.Protocol, Port: kp.DstPort, Seq: kp.Seq}, now)
+}
+
+// seenRecently 记录 key，duplicateWindow 内已记录过时返回 true
+func (s *KnockServer) seenRecently(key knockKey, now time.Time) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if seen, ok := s.recentKnocks[key]; ok && now.Sub(seen) < duplicateWindow {
+        return true
+    }
+    s.recentKnocks[key] = now
+
+    // 每个时间窗口清理一次过期记录
+    if now.Sub(s.lastSweep) >= duplicateWindow {
+        s.lastSweep = now
+        for k, t := range s.recentKnocks {
+            if now.Sub(t) >= duplicateWindow {
+                delete(s.recentKnocks, k)
+            }
+        }
+    }
+    return false
+}
+
+// grantDuration 返回一次授权的初始有效期：空闲超时模式下为 idle_seconds，否则为 expire_seconds
+func (s *KnockServer) grantDuration() time.Duration {
+    if s.cfg.IdleSeconds > 0 {
+        return s.cfg.IdleDuration()
+    }
+    return s.cfg.ExpireDuration()
+}
+
+// tryGrantLocked 在时间段和配额允许时为完成敲门的 srcIP 授权，client 为基于密钥的敲门方式
+// 识别出的客户端（可为 nil），调用方需持有 s.mu
+func (s *KnockServer) tryGrantLocked(srcIP string, state *KnockState, now time.Time, client *config.ClientConfig, reason string) bool {
+    notAfter, denied := s.checkScheduleLocked(now, client)
+    if denied == "" {
+        denied = s.checkQuotaLocked(srcIP, state, now)
+    }
+    if denied != "" {
+        utils.LogWarn("[%s] %s 敲门成功但不允许放行: %s", s.cfg.Name, srcIP, denied)
+        s.audit(utils.EventGrantDenied, srcIP, int(s.cfg.AllowPort), denied)
+        return false
+    }
+
+    until := now.Add(s.grantDuration())
+    if !notAfter.IsZero() && until.After(notAfter) {
+        until = notAfter
+    }
+    if err := s.grantLocked(srcIP, state, until, reason); err != nil {
+        return false
+    }
+    state.NotAfter = notAfter
+    s.dailyGrants[srcIP]++
+
+    // 用完当天配额的来源在次日之前不会再被放行
+    if max := s.cfg.MaxGrantsPerSourcePerDay; max > 0 && s.dailyGrants[srcIP] == max {
+        utils.LogWarn("[%s] %s 已用完当天的 %d 次授权，次日之前的敲门将被拒绝", s.cfg.Name, srcIP, max)
+        s.audit(utils.EventBan, srcIP, int(s.cfg.AllowPort), "daily grant quota exhausted")
+    }
+    return true
+}
+
+// checkScheduleLocked 检查服务和客户端的时间段，返回授权最晚的结束时间（零值表示不限制），
+// 不在时间段内时返回拒绝原因
+func (s *KnockServer) checkScheduleLocked(now time.Time, client *config.ClientConfig) (time.Time, string) {
+    active, end := s.cfg.Schedule.Active(now)
+    if !active {
+        return time.Time{}, "outside service schedule"
+    }
+    if client == nil {
+        return end, ""
+    }
+    active, clientEnd := client.Schedule.Active(now)
+    if !active {
+        return time.Time{}, "outside schedule of client " + client.Name
+    }
+    if end.IsZero() || (!clientEnd.IsZero() && clientEnd.Before(end)) {
+        end = clientEnd
+    }
+    return end, ""
+}
+
+// checkQuotaLocked 检查授权配额，超出时返回拒绝原因，调用方需持有 s.mu
+func (s *KnockServer) checkQuotaLocked(srcIP string, state *KnockState, now time.Time) string {
+    // 跨天后清空每日计数
+    if today := now.Format("2006-01-02"); today != s.dailyDate {
+        s.dailyDate = today
+        s.dailyGrants = make(map[string]int)
+    }
+    if max := s.cfg.MaxGrantsPerSourcePerDay; max > 0 && s.dailyGrants[srcIP] >= max {
+        return fmt.Sprintf("daily grant quota exceeded (%d)", max)
+    }
+
+    // 已有授权的来源重新敲门只是续期，不占用新的并发名额
+    if max := s.cfg.MaxActiveGrants; max > 0 && !(state.Granted && state.AllowedUntil.After(now)) {
+        active := 0
+        for _, st := range s.stateMap {
+            if st.Granted && st.AllowedUntil.After(now) {
+                active++
+            }
+        }
+        if active >= max {
+            return fmt.Sprintf("max active grants reached (%d)", max)
+        }
+    }
+    return ""
+}
+
+// grantLocked 放行 srcIP 访问 AllowPort 并在 until 时撤销，调用方需持有 s.mu；
+// 添加放行规则失败时返回错误，state 保持不变
+func (s *KnockServer) grantLocked(srcIP string, state *KnockState, until time.Time, reason string) error {
+    // 更新 nftables 规则的生效时间（可选）
+    err := s.nft.AllowIP(s.cfg.Name, srcIP, allowMatch(s.cfg).Port, s.cfg.ExpireSeconds, s.allowChain)
+    if err != nil {
+        utils.LogError("[%s] 放行失败: %v\n", s.cfg.Name, err)
+        return err
+    }
+
+    // 刷新允许时间
+    state.AllowedUntil = until
+    s.audit(utils.EventGrant, srcIP, int(s.cfg.AllowPort), reason)
+
+    // 已有撤销定时器时，它会按新的到期时间继续等待
+    if state.Granted {
+        return nil
     }
+    state.Granted = true
+    state.GrantID++
+    go s.expireGrant(srcIP, state, state.GrantID)
+    return nil
+}
+
+// endGrantLocked 立即结束授权并撤销放行规则，调用方需持有 s.mu，并在释放后对返回值调用 dropFlows
+func (s *KnockServer) endGrantLocked(ip string, state *KnockState, reason string) []conntrack.Flow {
+    state.Granted = false
+    state.AllowedUntil = time.Now()
+    return s.revoke(ip, reason)
+}
+
+// expireGrant 等待授权到期后撤销放行规则；到期时间被延长时继续等待，
+// 服务关闭（配置重载）时放弃撤销，由新的规则集接管
+func (s *KnockServer) expireGrant(ip string, state *KnockState, id uint64) {
+    for {
+        s.mu.Lock()
+        wait := time.Until(state.AllowedUntil)
+        s.mu.Unlock()
+
+        select {
+        case <-time.After(wait):
+        case <-s.closed:
+            return
+        }
+
+        s.mu.Lock()
+        // 授权已被提前结束（如单次连接已使用），本定时器作废
+        if !state.Granted || state.GrantID != id {
+            s.mu.Unlock()
+            return
+        }
+        if time.Now().Before(state.AllowedUntil) {
+            s.mu.Unlock()
+            continue
+        }
+        s.mu.Unlock()
+
+        // 遍历连接跟踪表较慢，不能持有 s.mu，否则会阻塞抓包和事件处理
+        live, err := s.liveFlows(ip)
+
+        s.mu.Lock()
+        if !state.Granted || state.GrantID != id {
+            s.mu.Unlock()
+            return
+        }
+        // 查询期间连接事件可能已经延长了授权
+        if time.Now().Before(state.AllowedUntil) || (err == nil && s.extendIfActiveLocked(ip, state, live)) {
+            s.mu.Unlock()
+            continue
+        }
+        reason := "expired"
+        if s.cfg.IdleSeconds > 0 {
+            reason = "idle"
+        }
+        flows := s.endGrantLocked(ip, state, reason)
+        s.mu.Unlock()
+        s.dropFlows(ip, flows)
+
+        utils.LogInfo("[%s] %s 授权过期，已撤销放行规则\n", s.cfg.Name, ip)
+        return
+    }
+}
+
+// liveFlows 空闲超时模式下返回来源到放行端口仍在使用的连接数，其他模式返回 0；
+// 以连接跟踪表为准，避免遗漏事件导致计数偏差。调用方不能持有 s.mu
+func (s *KnockServer) liveFlows(ip string) (int, error) {
+    if s.cfg.IdleSeconds <= 0 {
+        return 0, nil
+    }
+    n, err := conntrack.CountMatching(s.flowFrom(ip))
+    if err != nil {
+        utils.LogError("[%s] 查询 %s 的连接跟踪表项失败: %v", s.cfg.Name, ip, err)
+    }
+    return n, err
+}
+
+// extendIfActiveLocked 空闲超时模式下，若来源到放行端口仍有 n 个活跃连接则延长授权，调用方需持有 s.mu
+func (s *KnockServer) extendIfActiveLocked(ip string, state *KnockState, n int) bool {
+    if s.cfg.IdleSeconds <= 0 {
+        return false
+    }
+    state.LiveFlows = n
+    if n == 0 {
+        return false
+    }
+    // 续期不能越过授权时间段的结束时间
+    now := time.Now()
+    if !state.NotAfter.IsZero() && !now.Before(state.NotAfter) {
+        return false
+    }
+    state.AllowedUntil = now.Add(s.cfg.IdleDuration())
+    if !state.NotAfter.IsZero() && state.AllowedUntil.After(state.NotAfter) {
+        state.AllowedUntil = state.NotAfter
+    }
+    utils.LogDebug("[%s] %s 仍有 %d 个活跃连接，延长授权", s.cfg.Name, ip, n)
+    return true
+}
+
+// needsConntrackEvents 判断本服务是否依赖连接跟踪事件
+func (s *KnockServer) needsConntrackEvents() bool {
+    return s.cfg.IdleSeconds > 0 || s.cfg.GrantMode == config.GrantModeSingleConnection
+}
+
+// ownsFlow 判断连接跟踪表项的原始方向是否为发往本服务放行端口（及 listen_address）的 TCP / UDP 连接；
+// forward 模式下原始方向的目标是 DNAT 之前的本机地址和 allow_port
+func (s *KnockServer) ownsFlow(f conntrack.Flow) bool {
+    if f.Protocol != uint8(layers.IPProtocolTCP) && f.Protocol != uint8(layers.IPProtocolUDP) {
+        return false
+    }
+    return int(f.DstPort) == int(s.cfg.AllowPort) && s.listensOn(f.Dst)
+}
+
+// flowFrom 返回匹配来源 ip 发往本服务放行端口的连接的过滤函数
+func (s *KnockServer) flowFrom(ip string) func(conntrack.Flow) bool {
+    src := net.ParseIP(ip)
+    return func(f conntrack.Flow) bool {
+        return f.Src.Equal(src) && s.ownsFlow(f)
+    }
+}
+
+// HandleConntrackEvent 处理发往放行端口的连接跟踪事件：
+// 单次连接模式下第一个新连接出现即结束授权，空闲超时模式下据此延长授权
+func (s *KnockServer) HandleConntrackEvent(ev conntrack.Event) {
+    if !s.needsConntrackEvents() || !s.ownsFlow(ev.Flow) {
+        return
+    }
+    ip := ev.Flow.Src.String()
+
+    s.mu.Lock()
+    var flows []conntrack.Flow
+    defer func() {
+        s.mu.Unlock()
+        s.dropFlows(ip, flows)
+    }()
+
+    state, ok := s.stateMap[ip]
+    if !ok || !state.Granted {
+        return
+    }
+
+    if s.cfg.GrantMode == config.GrantModeSingleConnection {
+        if ev.Type == conntrack.EventNew {
+            utils.LogInfo("[%s] %s 已建立连接，单次授权结束", s.cfg.Name, ip)
+            flows = s.endGrantLocked(ip, state, "single connection used")
+        }
+        return
+    }
+
+    switch ev.Type {
+    case conntrack.EventNew:
+        state.LiveFlows++
+    case conntrack.EventDestroy:
+        if state.LiveFlows > 0 {
+            state.LiveFlows--
+        }
+    }
+
+    // 有连接活动，从现在起重新计算空闲时间
+    until := time.Now().Add(s.cfg.IdleDuration())
+    if !state.NotAfter.IsZero() && until.After(state.NotAfter) {
+        until = state.NotAfter
+    }
+    if until.After(state.AllowedUntil) {
+        state.AllowedUntil = until
+    }
+}
+
+// clientSecret 返回指定客户端的密钥
+func (s *KnockServer) clientSecret(name string) ([]byte, bool) {
+    // 只配置了 token / totp 的客户端不能用于基于密钥的敲门方式
+    if c := s.client(name); c != nil && c.Secret != "" {
+        return []byte(c.Secret), true
+    }
+    return nil, false
+}
+
+// client 按名称查找服务配置中的客户端
+func (s *KnockServer) client(name string) *config.ClientConfig {
+    for i := range s.cfg.Clients {
+        if s.cfg.Clients[i].Name == name {
+            return &s.cfg.Clients[i]
+        }
+    }
+    return nil
+}
+
+// HandleSPA 校验发往 SPAPort 的单包授权数据，校验通过后直接放行
+func (s *KnockServer) HandleSPA(srcIP string, payload []byte) {
+    if !s.geoAllowed(srcIP) {
+        return
+    }
+
+    now := time.Now()
+    pkt, err := spa.Verify(payload, s.cfg.Name, s.clientSecret, now, spa.DefaultMaxSkew)
+    if err != nil {
+        utils.LogWarn("[%s] %s 发送的 SPA 数据包无效: %v", s.cfg.Name, srcIP, err)
+        s.audit(utils.EventKnockReset, srcIP, int(s.cfg.SPAPort), "invalid spa packet")
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    // 时间戳超出容差后数据包不再被接受，nonce 至少保留到那时
+    if !s.useNonceLocked(string(pkt.Nonce), pkt.Timestamp.Add(spa.DefaultMaxSkew), now) {
+        utils.LogWarn("[%s] %s 重放了客户端 %s 的 SPA 数据包，已忽略", s.cfg.Name, srcIP, pkt.Client)
+        s.audit(utils.EventKnockReset, srcIP, int(s.cfg.SPAPort), "spa replay")
+        return
+    }
+
+    state, ok := s.stateMap[srcIP]
+    if !ok {
+        state = &KnockState{}
+    }
+    resetSequence(state)
+    state.LastTime = now
+    utils.LogInfo("[%s] %s 通过客户端 %s 的 SPA 校验", s.cfg.Name, srcIP, pkt.Client)
+    s.tryGrantLocked(srcIP, state, now, s.client(pkt.Client), "spa client "+pkt.Client)
+    s.stateMap[srcIP] = state
+}
+
+// useNonceLocked 记录一次性的 nonce 或令牌，已使用过时返回 false；
+// until 为它不再被校验接受的时间，记录保留到那时才清理。调用方需持有 s.mu
+func (s *KnockServer) useNonceLocked(nonce string, until, now time.Time) bool {
+    for n, t := range s.spaNonces {
+        if now.After(t) {
+            delete(s.spaNonces, n)
+        }
+    }
+    if _, seen := s.spaNonces[nonce]; seen {
+        return false
+    }
+    s.spaNonces[nonce] = until
+    return true
+}
+
+// HandleCovertSYN 检查发往放行端口的 TCP SYN 是否携带某个客户端的 covert SYN 令牌，
+// 携带时直接放行并返回 true；普通的连接尝试返回 false
+func (s *KnockServer) HandleCovertSYN(kp *knockPacket) bool {
+    now := time.Now()
+    for i := range s.cfg.Clients {
+        cl := &s.cfg.Clients[i]
+        if cl.Secret == "" {
+            continue
+        }
+        slot, ok := spa.MatchSYN(s.cfg.Name, s.cfg.AllowPort, []byte(cl.Secret), kp.Seq, kp.Window,
+            s.cfg.CovertSYN.CheckWindow, now, spa.DefaultMaxSkew)
+        if !ok {
+            continue
+        }
+
+        s.mu.Lock()
+        defer s.mu.Unlock()
+
+        // 同一客户端的同一时间片令牌只能使用一次，与 SPA nonce 共用重放检查
+        nonce := fmt.Sprintf("syn/%s/%d", cl.Name, slot)
+        if !s.useNonceLocked(nonce, spa.SlotExpiry(slot, spa.DefaultMaxSkew), now) {
+            utils.LogWarn("[%s] %s 重放了客户端 %s 的 covert SYN，已忽略", s.cfg.Name, kp.SrcIP, cl.Name)
+            s.audit(utils.EventKnockReset, kp.SrcIP, kp.DstPort, "covert syn replay")
+            return true
+        }
+
+        state, ok := s.stateMap[kp.SrcIP]
+        if !ok {
+            state = &KnockState{}
+        }
+        resetSequence(state)
+        state.LastTime = now
+        utils.LogInfo("[%s] %s 通过客户端 %s 的 covert SYN 校验", s.cfg.Name, kp.SrcIP, cl.Name)
+        s.tryGrantLocked(kp.SrcIP, state, now, cl, "covert syn client "+cl.Name)
+        s.stateMap[kp.SrcIP] = state
+        return true
+    }
+    return false
 }
 
 func getServiceNameByPort(portMap map[uint16]string, port uint16) string {
@@ -188,75 +913,184 @@ func contains(ports []int, port int) bool {
     return false
 }
 
-func runInterfaceListener(interfaceName string, services []*config.ServiceConfig, nft *nftmanager.Manager, portToService map[uint16]string, wg *sync.WaitGroup) {
-    defer wg.Done()
-
+// runInterfaceListener 在指定网卡上抓包并分发给各服务，抓包创建成功后调用 opened；
+// stop 关闭时返回 nil，抓包无法创建或网卡消失时返回错误，由调用方决定是否重试
+func runInterfaceListener(interfaceName string, servers []*KnockServer, decap *decapOptions, stop <-chan struct{}, opened func()) error {
     handle, err := afpacket.NewTPacket(
         afpacket.OptInterface(interfaceName),
         afpacket.OptFrameSize(65536),
+        afpacket.OptPollTimeout(500*time.Millisecond), // 定期返回以便检查 stop
     )
     if err != nil {
-        utils.LogWarn("创建抓包失败 (%s): %v", interfaceName, err)
-        return
+        return fmt.Errorf("创建抓包失败: %v", err)
     }
     defer handle.Close()
+    opened()
 
-    source := gopacket.NewPacketSource(handle, layers.LayerTypeEthernet)
-
-    var servers []*KnockServer
-    for _, svc := range services {
-        server := NewKnockServer(svc, nft, portToService)
-        servers = append(servers, server)
+    // WireGuard、tun 等三层网卡抓到的数据包没有以太网头
+    linkType := interfaceLinkType(interfaceName)
 
-        err := server.BlockAll()
-        if err != nil {
-            utils.LogInfo("[%s] 阻断所有IP访问目标端口失败: %v", svc.Name, err)
-        } else {
-            utils.LogInfo("🔔  服务 %s 监听网卡 %s，敲门序列 %v，放行端口 %d\n",
-                svc.Name, svc.Interface, svc.KnockPorts, svc.AllowPort)
+    for {
+        select {
+        case <-stop:
+            return nil
+        default:
         }
-    }
 
-    for packet := range source.Packets() {
-        netL := packet.NetworkLayer()
-        transL := packet.TransportLayer()
-        if netL == nil || transL == nil {
+        data, _, err := handle.ReadPacketData()
+        if err == afpacket.ErrTimeout {
+            continue
+        }
+        if err != nil {
+            // 网卡被删除后抓包句柄不会恢复，交给调用方重新创建
+            if _, ierr := net.InterfaceByName(interfaceName); ierr != nil {
+                return fmt.Errorf("读取数据包失败: %v（网卡已不存在）", err)
+            }
+            utils.LogWarn("读取数据包失败 (%s): %v", interfaceName, err)
+            time.Sleep(100 * time.Millisecond)
             continue
         }
+        packet := gopacket.NewPacket(data, decodeAs(linkType, data), gopacket.Default)
 
-        ip4, ok := netL.(*layers.IPv4)
+        kp, ok := parseKnockPacket(packet, decap)
         if !ok {
             continue
         }
+        srcIP, dstPort := kp.SrcIP, kp.DstPort
 
-        var dstPort int
-        var isKnock bool
-
-        if tcp, ok := transL.(*layers.TCP); ok {
-            if tcp.SYN && !tcp.ACK {
-                dstPort = int(tcp.DstPort)
-                isKnock = true
+        for _, server := range servers {
+            // 绑定了 listen_address 的服务只处理发往该地址的数据包
+            if !server.listensOn(kp.DstIP) {
+                continue
+            }
+            switch {
+            case kp.Protocol == layers.IPProtocolUDP && server.cfg.SPAPort != 0 && dstPort == int(server.cfg.SPAPort):
+                go server.HandleSPA(srcIP, kp.Payload)
+            case kp.Protocol == layers.IPProtocolUDP && server.cfg.DNS.Enabled && dstPort == int(server.cfg.DNS.ListenPort()):
+                go server.HandleDNS(kp)
+            case kp.Protocol == layers.IPProtocolICMPv4 || kp.Protocol == layers.IPProtocolICMPv6:
+                // ICMP Echo 只在 Identifier 命中敲门端口时计入，其余 ping 不影响敲门状态
+                if contains(server.cfg.KnockPorts, dstPort) {
+                    go server.HandlePacket(kp)
+                }
+            case dstPort == int(server.cfg.AllowPort) || contains(server.cfg.KnockPorts, dstPort):
+                go server.HandlePacket(kp)
+            default:
+                server.resetStateIfInvalidAccess(srcIP, dstPort)
             }
-        } else if udp, ok := transL.(*layers.UDP); ok {
-            dstPort = int(udp.DstPort)
-            isKnock = true
         }
+    }
+}
 
-        if !isKnock {
-            continue
+// interfaceLinkType 从 sysfs 读取网卡的链路类型（ARPHRD_*），读取失败时按以太网处理
+func interfaceLinkType(name string) int {
+    data, err := os.ReadFile(filepath.Join("/sys/class/net", name, "type"))
+    if err != nil {
+        return arphrdEther
+    }
+    t, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil {
+        return arphrdEther
+    }
+    return t
+}
+
+// 抓包需要区分的链路类型，见 linux/if_arp.h
+const (
+    arphrdEther = 1
+    arphrdPPP   = 512
+    arphrdNone  = 0xfffe // 无链路层头的三层网卡，如 WireGuard、tun
+)
+
+// decodeAs 根据链路类型返回数据包第一层的解码方式
+func decodeAs(linkType int, data []byte) gopacket.LayerType {
+    switch linkType {
+    case arphrdNone, arphrdPPP:
+        if len(data) > 0 && data[0]>>4 == 6 {
+            return layers.LayerTypeIPv6
         }
+        return layers.LayerTypeIPv4
+    }
+    return layers.LayerTypeEthernet
+}
 
-        srcIP := ip4.SrcIP.String()
+// parseKnockPacket 提取 IPv4 / IPv6 的 TCP SYN、UDP 和 ICMP Echo 请求；
+// 按 decap 逐层进入 VLAN、GRE、VXLAN 封装，以最内层的 IP 和传输层为准
+func parseKnockPacket(packet gopacket.Packet, decap *decapOptions) (*knockPacket, bool) {
+    return parseKnockLayers(packet.Layers(), decap, 0)
+}
 
-        for _, server := range servers {
-            if dstPort == int(server.cfg.AllowPort) || contains(server.cfg.KnockPorts, dstPort) {
-                go server.HandlePacket(srcIP, dstPort)
-            }else{
-                server.resetStateIfInvalidAccess(srcIP, dstPort)
+func parseKnockLayers(ls []gopacket.Layer, decap *decapOptions, depth int) (*knockPacket, bool) {
+    kp := &knockPacket{}
+    for i, l := range ls {
+        switch t := l.(type) {
+        case *layers.Dot1Q:
+            if !decap.VLAN {
+                return nil, false
             }
+        case *layers.IPv4:
+            kp.SrcIP, kp.DstIP = t.SrcIP.String(), t.DstIP
+        case *layers.IPv6:
+            kp.SrcIP, kp.DstIP = t.SrcIP.String(), t.DstIP
+        case *layers.GRE:
+            // 后续层是隧道内的 IP（或以太网）包
+            if !decap.GRE {
+                return nil, false
+            }
+        case *layers.VXLAN:
+            if !decap.VXLAN {
+                return nil, false
+            }
+        case *layers.ICMPv4:
+            if kp.DstIP == nil || t.TypeCode.Type() != layers.ICMPv4TypeEchoRequest {
+                return nil, false
+            }
+            kp.DstPort = int(t.Id)
+            kp.Seq = uint32(t.Seq)
+            kp.Protocol = layers.IPProtocolICMPv4
+            return kp, true
+        case *layers.ICMPv6:
+            if kp.DstIP == nil || t.TypeCode.Type() != layers.ICMPv6TypeEchoRequest || i+1 >= len(ls) {
+                return nil, false
+            }
+            echo, ok := ls[i+1].(*layers.ICMPv6Echo)
+            if !ok {
+                return nil, false
+            }
+            kp.DstPort = int(echo.Identifier)
+            kp.Seq = uint32(echo.SeqNumber)
+            kp.Protocol = layers.IPProtocolICMPv6
+            return kp, true
+        case *layers.TCP:
+            if kp.DstIP == nil || !t.SYN || t.ACK {
+                return nil, false
+            }
+            kp.SrcPort, kp.DstPort = int(t.SrcPort), int(t.DstPort)
+            kp.Protocol = layers.IPProtocolTCP
+            kp.Seq = t.Seq
+            kp.Window = t.Window
+            return kp, true
+        case *layers.UDP:
+            if kp.DstIP == nil {
+                return nil, false
+            }
+            if decap.isVXLAN(int(t.DstPort)) && depth < maxDecapDepth {
+                // gopacket 只按 4789 自动识别 VXLAN，其余端口手动解码
+                if i+1 < len(ls) && ls[i+1].LayerType() == layers.LayerTypeVXLAN {
+                    continue
+                }
+                inner := gopacket.NewPacket(t.Payload, layers.LayerTypeVXLAN, gopacket.Default)
+                return parseKnockLayers(inner.Layers(), decap, depth+1)
+            }
+            kp.SrcPort, kp.DstPort = int(t.SrcPort), int(t.DstPort)
+            kp.Protocol = layers.IPProtocolUDP
+            kp.Payload = t.Payload
+            return kp, true
         }
     }
+    return nil, false
 }
+
 func (s *KnockServer) resetStateIfInvalidAccess(srcIP string, dstPort int) bool {
     if dstPort == int(s.cfg.AllowPort) {
         return false
@@ -277,68 +1111,67 @@ func (s *KnockServer) resetStateIfInvalidAccess(srcIP string, dstPort int) bool
 
     now := time.Now()
 
+    // 敲门进行中且在 max_noise 范围内，视为噪声忽略
+    if state.SeqIndex > 0 && s.tolerateNoiseLocked(state) {
+        utils.LogDebug("[%s] %s 敲门过程中访问了无关端口 %d，按噪声忽略（%d/%d）\n",
+            s.cfg.Name, srcIP, dstPort, state.Noise, s.cfg.MaxNoise)
+        return false
+    }
+
     // 判断是否处于放行状态
     if state.AllowedUntil.IsZero() || now.After(state.AllowedUntil) {
         // ✅ 没有授权或授权已过期：删除整个状态
         delete(s.stateMap, srcIP)
         utils.LogError("[%s] %s 授权已过期或未获得授权，访问了无关端口 %d，已删除敲门状态\n",
             s.cfg.Name, srcIP, dstPort)
+        s.audit(utils.EventKnockReset, srcIP, dstPort, "unrelated port")
     } else {
-        // ❌ 还在放行期间：只清空 SeqIndex
-        state.SeqIndex = 0
+        // ❌ 还在放行期间：只清空敲门进度
+        resetSequence(state)
         s.stateMap[srcIP] = state
         utils.LogWarn("[%s] %s 当前处于放行期间，访问了无关端口 %d，已重置 SeqIndex\n",
             s.cfg.Name, srcIP, dstPort)
+        s.audit(utils.EventKnockReset, srcIP, dstPort, "unrelated port during grant")
     }
 
     return true
 }
 func main() {
+    switch {
+    case len(os.Args) > 1 && os.Args[1] == "knock":
+        os.Exit(runKnockCommand(os.Args[2:]))
+    case len(os.Args) > 1 && os.Args[1] == "check-config":
+        os.Exit(runCheckConfigCommand(os.Args[2:]))
+    case len(os.Args) > 1 && os.Args[1] == "status":
+        os.Exit(runStatusCommand(os.Args[2:]))
+    }
+
     versionFlag := flag.Bool("version", false, "Print version and exit")
+    configPath := flag.String("config", utils.DefaultConfigPath, "配置文件路径")
     flag.Parse()
 
     if *versionFlag {
         fmt.Println(Version)
         os.Exit(0)
-    }    
-    logFilePath := "/var/log/portknock/app.log"
-    if err := utils.InitLogger(logFilePath); err != nil {
-        log.Fatalf("日志初始化失败: %v", err)
-    }    
-    // ✅ 使用 utils 管理配置
-    if err := utils.EnsureConfigFileExists(); err != nil {
+    }
+    // ✅ 使用 utils 管理配置（此时日志只输出到 stdout）
+    if err := utils.EnsureConfigFileExists(*configPath); err != nil {
         log.Fatalf("配置检查失败: %v", err)
     }
 
-    cfg, err := utils.LoadAndValidateConfig()
+    cfg, err := utils.LoadAndValidateConfig(*configPath)
     if err != nil {
         log.Fatalf("加载配置失败: %v", err)
     }
 
-    utils.LogInfo("加载了 %d 个服务:\n", len(cfg.Services))
-
-    portToService := make(map[uint16]string)
-    for _, svc := range cfg.Services {
-        portToService[svc.AllowPort] = svc.Name
-        utils.LogInfo("服务名称: %s, 放行端口: %d, 敲门序列: %v, 网卡: %s",
-            svc.Name, svc.AllowPort, svc.KnockPorts, svc.Interface)
-    }
-
-    nft := nftmanager.NewManager()
-
-    interfaceMap := make(map[string][]*config.ServiceConfig)
-    for i := range cfg.Services {
-        svc := &cfg.Services[i]
-        interfaceMap[svc.Interface] = append(interfaceMap[svc.Interface], svc)
+    if err := applyGlobalConfig(cfg); err != nil {
+        log.Fatalf("%v", err)
     }
+    utils.Audit(utils.AuditEvent{Event: utils.EventConfigReload, Reason: "startup"})
 
-    var wg sync.WaitGroup
-    for intf, svcs := range interfaceMap {
-        wg.Add(1)
-        go func(interfaceName string, services []*config.ServiceConfig) {
-            runInterfaceListener(interfaceName, services, nft, portToService, &wg)
-        }(intf, svcs)
+    d := &daemon{configPath: *configPath, cfg: cfg, started: time.Now()}
+    if err := d.start(nil); err != nil {
+        log.Fatalf("启动失败: %v", err)
     }
-
-    wg.Wait()
-}
\ No newline at end of file
+    d.run()
+}
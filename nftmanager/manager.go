@@ -5,17 +5,42 @@ import (
     "net"
     "strings"
     "sync"
+    "time"
 
     "github.com/google/nftables"
+    "github.com/google/nftables/binaryutil"
     "github.com/google/nftables/expr"
     "golang.org/x/sys/unix"
 	"portknock/utils"
 )
 
-// RuleKey 表示规则的唯一标识：IP + Port
+// RuleKey 表示规则的唯一标识：Service + IP + Port
 type RuleKey struct {
-    IP   string
-    Port int
+    Service string
+    IP      string
+    Port    int
+}
+
+// Match 描述服务规则匹配的流量：目标端口、可选的目标地址，以及规则挂在哪个钩子上
+type Match struct {
+    Port    int
+    Addr    net.IP // 目标地址，nil 表示所有地址
+    Forward bool   // 为 true 时规则位于 forward 钩子的 pkforward 链，匹配 DNAT 之后的目标
+}
+
+// portKey 标识主链上按钩子 + 目标地址 + 端口去重的规则，Addr 为空表示匹配所有地址
+type portKey struct {
+    Forward bool
+    Addr    string
+    Port    int
+}
+
+func (t Match) key() portKey {
+    k := portKey{Forward: t.Forward, Port: t.Port}
+    if t.Addr != nil {
+        k.Addr = t.Addr.String()
+    }
+    return k
 }
 
 // Manager 负责与 nftables 交互，管理敲门规则
@@ -23,31 +48,37 @@ type Manager struct {
     conn       *nftables.Conn
     table      *nftables.Table
     blockChain *nftables.Chain // 主链 pkinput
+    forwardChain *nftables.Chain // forward 钩子上的主链 pkforward，首次使用时创建
     mutex      sync.Mutex
     rulesByIP  map[RuleKey]*nftables.Rule // 每个 (ip, port) 对应一条规则
-    blockedPorts map[int]bool              // 防止重复添加 drop 规则
+    blockedPorts map[portKey]bool          // 防止重复添加 drop 规则
+    establishedPorts map[portKey]bool      // 防止重复添加 established 放行规则
 }
 
-// NewManager 创建一个新的 nftables 管理器
-func NewManager() *Manager {
+// NewManager 创建一个新的 nftables 管理器，并重建 portknock 表和主链
+func NewManager() (*Manager, error) {
     m := &Manager{
         conn:        &nftables.Conn{},
         table:       nil,
         blockChain:  nil,
         rulesByIP:   make(map[RuleKey]*nftables.Rule),
-        blockedPorts: make(map[int]bool),
+        blockedPorts: make(map[portKey]bool),
+        establishedPorts: make(map[portKey]bool),
     }
     // 检查是否已有 portknock 表，有则删除
     tables, err := m.conn.ListTables()
     if err != nil {
-        panic(err) // 或者处理错误
-    }    
+        return nil, fmt.Errorf("列出 nftables 表失败: %v", err)
+    }
     for _, t := range tables {
         if t.Name == "portknock" && t.Family == nftables.TableFamilyINet {
             m.conn.DelTable(t)
         }
     }
-    m.conn.Flush() // 先提交删除操作
+    // 先提交删除操作
+    if err := m.conn.Flush(); err != nil {
+        return nil, fmt.Errorf("删除旧的 portknock 表失败: %v", err)
+    }
 
     // 创建表 portknock
     m.table = m.conn.AddTable(&nftables.Table{
@@ -71,13 +102,13 @@ func NewManager() *Manager {
 
     // 提交规则
     if err := m.conn.Flush(); err != nil {
-        panic(err)
+        return nil, fmt.Errorf("创建 portknock 表失败: %v", err)
     }
 
     // 初始化字段
     m.blockChain = blockChain
     utils.LogInfo("初始化表完成")
-    return m
+    return m, nil
 }
 
 // deleteChainIfExist 删除指定名称的链（如果存在）
@@ -99,57 +130,91 @@ func (m *Manager) deleteChainIfExist(chainName string) error {
     return nil
 }
 
-// AddBlockRule 阻止所有 IP 访问指定端口（TCP 和 UDP），加在主链 pkinput 上
-func (m *Manager) AddBlockRule(serviceName string, port int) error {
+// 阻断动作
+const (
+    BlockActionDrop            = "drop"             // 静默丢弃（端口表现为 filtered）
+    BlockActionTCPReset        = "tcp-reset"        // TCP 回复 RST，UDP 回复 ICMP 端口不可达（端口表现为 closed）
+    BlockActionICMPUnreachable = "icmp-unreachable" // TCP / UDP 均回复 ICMP / ICMPv6 端口不可达
+)
+
+// AddBlockRule 阻止所有 IP 访问 target 匹配的端口（TCP 和 UDP），加在主链 pkinput（或 pkforward）上，
+// action 为空时等同于 drop
+func (m *Manager) AddBlockRule(serviceName string, target Match, action string) error {
     m.mutex.Lock()
     defer m.mutex.Unlock()
 
     // 防止重复添加 drop 规则
-    if m.blockedPorts[port] {
+    key := target.key()
+    if m.blockedPorts[key] {
         return nil
     }
+    chain := m.baseChain(target.Forward)
+
+    for _, proto := range []byte{unix.IPPROTO_TCP, unix.IPPROTO_UDP} {
+        // （forward 时 ct status dnat）且（目标地址匹配）且 协议 == proto 且 目标端口匹配
+        exprs := dnatMatchExprs(target.Forward)
+        exprs = append(exprs, addrMatchExprs(target.Addr, false)...)
+        exprs = append(exprs, portMatchExprs(proto, target.Port)...)
+
+        // 拒绝
+        verdict, err := blockVerdict(action, proto)
+        if err != nil {
+            return err
+        }
+        exprs = append(exprs, verdict...)
 
-    // TCP 规则：阻止访问目标端口
-    tcpRule := &nftables.Rule{
-        Table: m.table,
-        Chain: m.blockChain,
-        Exprs: []expr.Any{
-            // 协议 == TCP
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 9, Len: 1},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{unix.IPPROTO_TCP}},
-
-            // 目标端口 == port
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{byte(port >> 8), byte(port & 0xff)}},
-
-            // 拒绝
-            &expr.Verdict{Kind: expr.VerdictDrop},
-        },
+        m.conn.AddRule(&nftables.Rule{
+            Table: m.table,
+            Chain: chain,
+            Exprs: exprs,
+        })
     }
+    m.blockedPorts[key] = true
 
-    // UDP 规则：阻止访问目标端口
-    udpRule := &nftables.Rule{
-        Table: m.table,
-        Chain: m.blockChain,
-        Exprs: []expr.Any{
-            // 协议 == UDP
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 9, Len: 1},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{unix.IPPROTO_UDP}},
-
-            // 目标端口 == port
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{byte(port >> 8), byte(port & 0xff)}},
-
-            // 拒绝
-            &expr.Verdict{Kind: expr.VerdictDrop},
-        },
+    return m.conn.Flush()
+}
+
+// baseChain 返回 pkinput，forward 为 true 时返回 pkforward（不存在则创建，随调用方的 Flush 提交），
+// 调用方需持有 m.mutex
+func (m *Manager) baseChain(forward bool) *nftables.Chain {
+    if !forward {
+        return m.blockChain
+    }
+    if m.forwardChain == nil {
+        // 与 pkinput 一样默认 accept，只处理本程序添加的规则
+        policy := nftables.ChainPolicyAccept
+        m.forwardChain = m.conn.AddChain(&nftables.Chain{
+            Name:     "pkforward",
+            Table:    m.table,
+            Type:     nftables.ChainTypeFilter,
+            Hooknum:  nftables.ChainHookForward,
+            Priority: nftables.ChainPriorityFilter,
+            Policy:   &policy,
+        })
+        utils.LogInfo("已创建 forward 主链 pkforward")
     }
+    return m.forwardChain
+}
 
-    m.conn.AddRule(tcpRule)
-    m.conn.AddRule(udpRule)
-    m.blockedPorts[port] = true
+// blockVerdict 根据阻断动作生成规则末尾的表达式
+func blockVerdict(action string, proto byte) ([]expr.Any, error) {
+    // inet 表中使用 ICMPX，内核会按 IPv4 / IPv6 自动回复 ICMP 或 ICMPv6
+    portUnreachable := []expr.Any{
+        &expr.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: unix.NFT_REJECT_ICMPX_PORT_UNREACH},
+    }
 
-    return m.conn.Flush()
+    switch action {
+    case "", BlockActionDrop:
+        return []expr.Any{&expr.Verdict{Kind: expr.VerdictDrop}}, nil
+    case BlockActionTCPReset:
+        if proto == unix.IPPROTO_TCP {
+            return []expr.Any{&expr.Reject{Type: unix.NFT_REJECT_TCP_RST}}, nil
+        }
+        return portUnreachable, nil
+    case BlockActionICMPUnreachable:
+        return portUnreachable, nil
+    }
+    return nil, fmt.Errorf("未知的阻断动作: %s", action)
 }
 
 // AllowIP 放行指定 IP 访问指定端口（只加到 allowChain 中）
@@ -157,13 +222,16 @@ func (m *Manager) AllowIP(serviceName, srcIP string, port int, expireSeconds int
     m.mutex.Lock()
     defer m.mutex.Unlock()
 
-    ip := net.ParseIP(srcIP).To4()
+    ip := net.ParseIP(srcIP)
     if ip == nil {
-        utils.LogError("invalid ipv4")
-        return fmt.Errorf("invalid ipv4")
+        utils.LogError("invalid ip: %s", srcIP)
+        return fmt.Errorf("invalid ip: %s", srcIP)
+    }
+    if ip4 := ip.To4(); ip4 != nil {
+        ip = ip4
     }
     
-    key := RuleKey{IP: srcIP, Port: port}
+    key := RuleKey{Service: serviceName, IP: srcIP, Port: port}
     // 获取当前链上所有规则
     rules, GetRulesErr := m.conn.GetRules(m.table, allowChain)
     if GetRulesErr != nil {
@@ -191,21 +259,20 @@ func (m *Manager) AllowIP(serviceName, srcIP string, port int, expireSeconds int
         }
     }
     
+    // 匹配源 IP 地址
+    exprs := addrMatchExprs(ip, true)
+    exprs = append(exprs,
+        // 匹配目标端口
+        &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+        &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{byte(port >> 8), byte(port & 0xff)}},
+
+        // 放行
+        &expr.Verdict{Kind: expr.VerdictAccept},
+    )
     rule := &nftables.Rule{
         Table: m.table,
         Chain: allowChain, // 使用传入的专属链
-        Exprs: []expr.Any{
-            // 匹配源 IP 地址
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: ip},
-
-            // 匹配目标端口
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{byte(port >> 8), byte(port & 0xff)}},
-
-            // 放行
-            &expr.Verdict{Kind: expr.VerdictAccept},
-        },
+        Exprs: exprs,
         UserData: []byte(fmt.Sprintf("service:%s,ip:%s,port:%d", serviceName, srcIP, port)),
     }
 
@@ -226,7 +293,7 @@ func (m *Manager) RevokeIP(serviceName, ip string, port int, allowChain *nftable
     m.mutex.Lock()
     defer m.mutex.Unlock()
 
-    key := RuleKey{IP: ip, Port: port}
+    key := RuleKey{Service: serviceName, IP: ip, Port: port}
     delete(m.rulesByIP, key)
 
     // 获取当前链上所有规则
@@ -255,8 +322,8 @@ func (m *Manager) RevokeIP(serviceName, ip string, port int, allowChain *nftable
     return m.conn.Flush()
 }
 
-// CreateAllowChain 为某个服务创建专属放行链，并插入跳转规则到主链
-func (m *Manager) CreateAllowChain(serviceName string, port int) (*nftables.Chain, error) {
+// CreateAllowChain 为某个服务创建专属放行链，并向 target 所在的主链插入跳转规则
+func (m *Manager) CreateAllowChain(serviceName string, target Match) (*nftables.Chain, error) {
     m.mutex.Lock()
     defer m.mutex.Unlock()
 
@@ -281,20 +348,18 @@ func (m *Manager) CreateAllowChain(serviceName string, port int) (*nftables.Chai
         Type:  nftables.ChainTypeFilter,
     })
 
-    // 插入 jump 到该链的规则（主链 pkinput）
+    // 插入 jump 到该链的规则（主链 pkinput 或 pkforward）
+    // （forward 时 ct status dnat）+（目标地址匹配）+ TCP 协议 + 目标端口匹配
+    exprs := dnatMatchExprs(target.Forward)
+    exprs = append(exprs, addrMatchExprs(target.Addr, false)...)
+    exprs = append(exprs, portMatchExprs(unix.IPPROTO_TCP, target.Port)...)
+    exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictJump, Chain: chainName}) // 跳转到专属链
     jumpRule := &nftables.Rule{
-        Exprs: []expr.Any{
-            // TCP 协议 + 目标端口匹配
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 9, Len: 1},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{unix.IPPROTO_TCP}},
-            &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
-            &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{byte(port >> 8), byte(port & 0xff)}},
-            &expr.Verdict{Kind: expr.VerdictJump, Chain: chainName}, // 跳转到专属链
-        },
+        Exprs: exprs,
         UserData: []byte(fmt.Sprintf("jump-%s", chainName)),
     }
     jumpRule.Table = m.table
-    jumpRule.Chain = m.blockChain
+    jumpRule.Chain = m.baseChain(target.Forward)
     m.conn.InsertRule(jumpRule)
 
     // 提交规则
@@ -302,10 +367,199 @@ func (m *Manager) CreateAllowChain(serviceName string, port int) (*nftables.Chai
     if err != nil {
         return nil, err
     }
-    utils.LogInfo("为 %d 端口创建 %s 表成功",port, serviceName)
+    utils.LogInfo("为 %d 端口创建 %s 表成功",target.Port, serviceName)
     return allowChain, nil
 }
 
+// AddEstablishedRule 在主链 pkinput 中放行目标端口上已建立 / 相关的连接（ct state established,related），
+// 这样授权到期、放行规则被撤销后，授权期间建立的会话不会被 drop 规则中断
+func (m *Manager) AddEstablishedRule(serviceName string, target Match) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    key := target.key()
+    if m.establishedPorts[key] {
+        return nil
+    }
+    chain := m.baseChain(target.Forward)
+
+    for _, proto := range []byte{unix.IPPROTO_TCP, unix.IPPROTO_UDP} {
+        exprs := []expr.Any{
+            // ct state established,related
+            &expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+            &expr.Bitwise{
+                SourceRegister: 1,
+                DestRegister:   1,
+                Len:            4,
+                Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+                Xor:            binaryutil.NativeEndian.PutUint32(0),
+            },
+            &expr.Cmp{Register: 1, Op: expr.CmpOpNeq, Data: []byte{0, 0, 0, 0}},
+        }
+        exprs = append(exprs, dnatMatchExprs(target.Forward)...)
+        exprs = append(exprs, addrMatchExprs(target.Addr, false)...)
+        exprs = append(exprs, portMatchExprs(proto, target.Port)...)
+        exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictAccept})
+
+        // 插入到主链最前面，保证先于 drop 规则匹配
+        m.conn.InsertRule(&nftables.Rule{
+            Table:    m.table,
+            Chain:    chain,
+            Exprs:    exprs,
+            UserData: []byte(fmt.Sprintf("established-%s", serviceName)),
+        })
+    }
+    m.establishedPorts[key] = true
+
+    if err := m.conn.Flush(); err != nil {
+        return err
+    }
+    utils.LogInfo("[%s] 已放行 %d 端口上已建立的连接", serviceName, target.Port)
+    return nil
+}
+
+// AddRateLimit 在服务专属放行链最前面添加按来源 IP 的新建连接限速（meter）：
+// 每个来源每 per（秒或分钟）最多 rate 个新连接，允许突发 burst 个，超出部分直接丢弃
+func (m *Manager) AddRateLimit(serviceName string, allowChain *nftables.Chain, rate uint64, per time.Duration, burst uint32) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    var unit expr.LimitTime
+    switch per {
+    case time.Second:
+        unit = expr.LimitTimeSecond
+    case time.Minute:
+        unit = expr.LimitTimeMinute
+    default:
+        return fmt.Errorf("不支持的限速周期: %v", per)
+    }
+
+    // IPv4 和 IPv6 来源各用一个动态集合（键类型不同），条目空闲一段时间后自动过期
+    families := []struct {
+        nfproto byte
+        suffix  string
+        keyType nftables.SetDatatype
+        offset  uint32 // 网络层头中来源地址的偏移
+        len     uint32
+    }{
+        {unix.NFPROTO_IPV4, "", nftables.TypeIPAddr, 12, 4},
+        {unix.NFPROTO_IPV6, "6", nftables.TypeIP6Addr, 8, 16},
+    }
+    for _, f := range families {
+        meter := &nftables.Set{
+            Table:      m.table,
+            Name:       fmt.Sprintf("%s_ratelimit%s", serviceName, f.suffix),
+            KeyType:    f.keyType,
+            Dynamic:    true,
+            HasTimeout: true,
+            Timeout:    2 * per,
+        }
+        if err := m.conn.AddSet(meter, nil); err != nil {
+            return err
+        }
+
+        rule := &nftables.Rule{
+            Table: m.table,
+            Chain: allowChain,
+            Exprs: []expr.Any{
+                // ct state new
+                &expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+                &expr.Bitwise{
+                    SourceRegister: 1,
+                    DestRegister:   1,
+                    Len:            4,
+                    Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitNEW),
+                    Xor:            binaryutil.NativeEndian.PutUint32(0),
+                },
+                &expr.Cmp{Register: 1, Op: expr.CmpOpNeq, Data: []byte{0, 0, 0, 0}},
+
+                // 以来源 IP 为键更新 meter，超过限速时匹配
+                &expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+                &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{f.nfproto}},
+                &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: f.offset, Len: f.len},
+                &expr.Dynset{
+                    SrcRegKey: 1,
+                    SetName:   meter.Name,
+                    SetID:     meter.ID,
+                    Operation: uint32(unix.NFT_DYNSET_OP_UPDATE),
+                    Exprs: []expr.Any{
+                        &expr.Limit{Type: expr.LimitTypePkts, Rate: rate, Over: true, Unit: unit, Burst: burst},
+                    },
+                },
+
+                // 超速丢弃
+                &expr.Verdict{Kind: expr.VerdictDrop},
+            },
+            UserData: []byte(fmt.Sprintf("ratelimit%s-%s", f.suffix, serviceName)),
+        }
+        m.conn.InsertRule(rule)
+    }
+
+    if err := m.conn.Flush(); err != nil {
+        return err
+    }
+    utils.LogInfo("[%s] 已启用新建连接限速: %d/%v，突发 %d", serviceName, rate, per, burst)
+    return nil
+}
+
+// portMatchExprs 生成 "协议 == proto 且 目标端口 == port" 的匹配表达式，
+// 使用 meta l4proto 以同时适用于 IPv4 和 IPv6
+func portMatchExprs(proto byte, port int) []expr.Any {
+    return []expr.Any{
+        &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+        &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{proto}},
+        &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+        &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{byte(port >> 8), byte(port & 0xff)}},
+    }
+}
+
+// ipsDstNAT 对应连接跟踪状态位 IPS_DST_NAT（见 linux/netfilter/nf_conntrack_common.h）
+const ipsDstNAT = 1 << 5
+
+// dnatMatchExprs 在 forward 为 true 时生成 "ct status dnat" 匹配表达式，
+// 使 pkforward 中的规则只作用于经 DNAT 转发进来的连接，不影响容器 / 虚拟机主动访问外部的流量
+func dnatMatchExprs(forward bool) []expr.Any {
+    if !forward {
+        return nil
+    }
+    return []expr.Any{
+        &expr.Ct{Register: 1, Key: expr.CtKeySTATUS},
+        &expr.Bitwise{
+            SourceRegister: 1,
+            DestRegister:   1,
+            Len:            4,
+            Mask:           binaryutil.NativeEndian.PutUint32(ipsDstNAT),
+            Xor:            binaryutil.NativeEndian.PutUint32(0),
+        },
+        &expr.Cmp{Register: 1, Op: expr.CmpOpNeq, Data: []byte{0, 0, 0, 0}},
+    }
+}
+
+// addrMatchExprs 生成 "ip saddr/daddr == addr" 或 "ip6 saddr/daddr == addr" 的匹配表达式，
+// addr 为 nil 时不做限制
+func addrMatchExprs(addr net.IP, src bool) []expr.Any {
+    if addr == nil {
+        return nil
+    }
+
+    family, offset := byte(unix.NFPROTO_IPV6), uint32(24)
+    if src {
+        offset = 8
+    }
+    if ip4 := addr.To4(); ip4 != nil {
+        addr = ip4
+        family, offset = unix.NFPROTO_IPV4, 16
+        if src {
+            offset = 12
+        }
+    }
+    return []expr.Any{
+        &expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+        &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: []byte{family}},
+        &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: uint32(len(addr))},
+        &expr.Cmp{Register: 1, Op: expr.CmpOpEq, Data: addr},
+    }
+}
 
 // Conn 导出 conn 字段
 func (m *Manager) Conn() *nftables.Conn {
@@ -317,6 +571,11 @@ func (m *Manager) Table() *nftables.Table {
     return m.table
 }
 
+// ForwardChain 导出 forward 主链，尚未创建时为 nil
+func (m *Manager) ForwardChain() *nftables.Chain {
+    return m.forwardChain
+}
+
 // MainChain 导出主链
 func (m *Manager) MainChain() *nftables.Chain {
     return m.blockChain
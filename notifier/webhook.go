@@ -0,0 +1,208 @@
+package notifier
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "text/template"
+    "time"
+
+    "portknock/config"
+    "portknock/utils"
+)
+
+// 未配置 events 时默认订阅的事件
+var defaultEvents = []string{utils.EventGrant, utils.EventRevoke, utils.EventBan}
+
+// queueSize 每个 Webhook 待发送事件队列的长度，队列满时丢弃新事件，避免目标不可用时堆积
+const queueSize = 256
+
+// Notifier 将审计事件异步推送到 Webhook
+type Notifier struct {
+    hooks     []*webhook
+    done      chan struct{} // Close 时关闭，通知发送协程退出
+    closeOnce sync.Once
+}
+
+type webhook struct {
+    cfg     config.WebhookConfig
+    events  map[string]bool
+    tmpl    *template.Template
+    backoff time.Duration
+    client  *http.Client
+    queue   chan utils.AuditEvent // 由该 Webhook 唯一的发送协程按顺序消费
+    dropped uint64                // 因队列已满被丢弃的事件数
+}
+
+// templateData 是 Webhook 模板可以使用的字段
+type templateData struct {
+    utils.AuditEvent
+    Hostname string
+}
+
+var templateFuncs = template.FuncMap{
+    // json 将值编码为 JSON，便于在 JSON 模板中安全地嵌入字符串
+    "json": func(v interface{}) (string, error) {
+        data, err := json.Marshal(v)
+        return string(data), err
+    },
+}
+
+// New 根据配置创建通知器并为每个 Webhook 启动一个发送协程，模板有误时返回错误；
+// 不再使用时应调用 Close
+func New(cfg config.NotificationsConfig) (*Notifier, error) {
+    n := &Notifier{done: make(chan struct{})}
+    for i, hc := range cfg.Webhooks {
+        name := hc.Name
+        if name == "" {
+            name = fmt.Sprintf("webhook[%d]", i)
+        }
+        if hc.URL == "" {
+            return nil, fmt.Errorf("%s 未配置 url", name)
+        }
+
+        h := &webhook{
+            cfg:     hc,
+            events:  make(map[string]bool),
+            backoff: time.Duration(hc.BackoffMs) * time.Millisecond,
+            client:  &http.Client{Timeout: time.Duration(hc.TimeoutSeconds) * time.Second},
+            queue:   make(chan utils.AuditEvent, queueSize),
+        }
+        h.cfg.Name = name
+        if h.cfg.Method == "" {
+            h.cfg.Method = http.MethodPost
+        }
+        if h.cfg.ContentType == "" {
+            h.cfg.ContentType = "application/json"
+        }
+        if h.backoff <= 0 {
+            h.backoff = time.Second
+        }
+        if h.client.Timeout <= 0 {
+            h.client.Timeout = 5 * time.Second
+        }
+
+        events := hc.Events
+        if len(events) == 0 {
+            events = defaultEvents
+        }
+        for _, e := range events {
+            h.events[e] = true
+        }
+
+        if hc.Template != "" {
+            tmpl, err := template.New(name).Funcs(templateFuncs).Parse(hc.Template)
+            if err != nil {
+                return nil, fmt.Errorf("%s 模板解析失败: %v", name, err)
+            }
+            h.tmpl = tmpl
+        }
+        n.hooks = append(n.hooks, h)
+    }
+    for _, h := range n.hooks {
+        go h.run(n.done)
+    }
+    return n, nil
+}
+
+// Close 停止所有发送协程，队列中尚未发送的事件被丢弃；可重复调用
+func (n *Notifier) Close() {
+    n.closeOnce.Do(func() { close(n.done) })
+}
+
+// Notify 将事件放入所有订阅了该事件的 Webhook 的发送队列，不会阻塞（可直接作为 utils.AuditSink 使用）；
+// 队列已满时丢弃该事件并计数
+func (n *Notifier) Notify(ev utils.AuditEvent) {
+    for _, h := range n.hooks {
+        if !h.events[ev.Event] && !h.events["*"] {
+            continue
+        }
+        select {
+        case h.queue <- ev:
+        default:
+            dropped := atomic.AddUint64(&h.dropped, 1)
+            utils.LogWarn("[notify] %s 发送队列已满，丢弃 %s 事件（累计丢弃 %d 条）", h.cfg.Name, ev.Event, dropped)
+        }
+    }
+}
+
+// run 按顺序发送队列中的事件，直到 done 被关闭
+func (h *webhook) run(done <-chan struct{}) {
+    for {
+        select {
+        case ev := <-h.queue:
+            h.deliver(ev, done)
+        case <-done:
+            return
+        }
+    }
+}
+
+// deliver 渲染并发送一次通知，失败时按指数退避重试，done 关闭时放弃剩余的重试
+func (h *webhook) deliver(ev utils.AuditEvent, done <-chan struct{}) {
+    body, err := h.render(ev)
+    if err != nil {
+        utils.LogError("[notify] %s 渲染模板失败: %v", h.cfg.Name, err)
+        return
+    }
+
+    wait := h.backoff
+    for attempt := 0; ; attempt++ {
+        err = h.send(body)
+        if err == nil {
+            utils.LogDebug("[notify] %s 已发送 %s 事件", h.cfg.Name, ev.Event)
+            return
+        }
+        if attempt >= h.cfg.Retries {
+            break
+        }
+        utils.LogWarn("[notify] %s 发送失败（第 %d 次）: %v，%v 后重试", h.cfg.Name, attempt+1, err, wait)
+        select {
+        case <-time.After(wait):
+        case <-done:
+            return
+        }
+        wait *= 2
+    }
+    utils.LogError("[notify] %s 发送 %s 事件失败，已放弃: %v", h.cfg.Name, ev.Event, err)
+}
+
+func (h *webhook) render(ev utils.AuditEvent) ([]byte, error) {
+    if h.tmpl == nil {
+        return json.Marshal(ev)
+    }
+    hostname, _ := os.Hostname()
+    var buf bytes.Buffer
+    if err := h.tmpl.Execute(&buf, templateData{AuditEvent: ev, Hostname: hostname}); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (h *webhook) send(body []byte) error {
+    req, err := http.NewRequest(strings.ToUpper(h.cfg.Method), h.cfg.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", h.cfg.ContentType)
+    req.Header.Set("User-Agent", "portknock")
+    for k, v := range h.cfg.Headers {
+        req.Header.Set(k, v)
+    }
+
+    resp, err := h.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("HTTP %d", resp.StatusCode)
+    }
+    return nil
+}
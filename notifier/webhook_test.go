@@ -0,0 +1,190 @@
+package notifier
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "portknock/config"
+    "portknock/utils"
+)
+
+// recorder 是记录收到的请求体的测试 Webhook 目标，前 failFirst 次请求返回 500
+type recorder struct {
+    mu        sync.Mutex
+    bodies    [][]byte
+    times     []time.Time
+    failFirst int
+    got       chan struct{}
+}
+
+func newRecorder(failFirst int) (*recorder, *httptest.Server) {
+    r := &recorder{failFirst: failFirst, got: make(chan struct{}, 16)}
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        body, _ := io.ReadAll(req.Body)
+        r.mu.Lock()
+        r.bodies = append(r.bodies, body)
+        r.times = append(r.times, time.Now())
+        fail := len(r.bodies) <= r.failFirst
+        r.mu.Unlock()
+        if fail {
+            w.WriteHeader(http.StatusInternalServerError)
+        }
+        r.got <- struct{}{}
+    }))
+    return r, srv
+}
+
+func (r *recorder) wait(t *testing.T, n int) {
+    t.Helper()
+    for i := 0; i < n; i++ {
+        select {
+        case <-r.got:
+        case <-time.After(5 * time.Second):
+            t.Fatalf("等待第 %d 个请求超时", i+1)
+        }
+    }
+}
+
+func (r *recorder) count() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return len(r.bodies)
+}
+
+func newNotifier(t *testing.T, hc config.WebhookConfig) *Notifier {
+    t.Helper()
+    n, err := New(config.NotificationsConfig{Webhooks: []config.WebhookConfig{hc}})
+    if err != nil {
+        t.Fatalf("New: %v", err)
+    }
+    t.Cleanup(n.Close)
+    return n
+}
+
+func TestWebhookDelivery(t *testing.T) {
+    rec, srv := newRecorder(0)
+    defer srv.Close()
+
+    n := newNotifier(t, config.WebhookConfig{
+        URL:      srv.URL,
+        Template: `{"text": {{json (printf "%s %s %d" .Service .SrcIP .Port)}}}`,
+    })
+    n.Notify(utils.AuditEvent{Event: utils.EventGrant, Service: "ssh", SrcIP: "203.0.113.7", Port: 22})
+    rec.wait(t, 1)
+
+    var body struct{ Text string }
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    if err := json.Unmarshal(rec.bodies[0], &body); err != nil {
+        t.Fatalf("请求体不是合法 JSON: %v (%s)", err, rec.bodies[0])
+    }
+    if body.Text != "ssh 203.0.113.7 22" {
+        t.Errorf("text = %q", body.Text)
+    }
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+    rec, srv := newRecorder(0)
+    defer srv.Close()
+
+    // 默认只订阅 grant / revoke / ban
+    n := newNotifier(t, config.WebhookConfig{URL: srv.URL})
+    n.Notify(utils.AuditEvent{Event: utils.EventKnockStep, SrcIP: "203.0.113.7"})
+    n.Notify(utils.AuditEvent{Event: utils.EventRevoke, SrcIP: "203.0.113.8"})
+    rec.wait(t, 1)
+
+    var ev utils.AuditEvent
+    rec.mu.Lock()
+    first := rec.bodies[0]
+    rec.mu.Unlock()
+    if err := json.Unmarshal(first, &ev); err != nil {
+        t.Fatalf("请求体不是合法 JSON: %v", err)
+    }
+    if ev.Event != utils.EventRevoke || ev.SrcIP != "203.0.113.8" {
+        t.Errorf("收到了错误的事件: %+v", ev)
+    }
+
+    // 显式订阅的事件列表替换默认值
+    rec2, srv2 := newRecorder(0)
+    defer srv2.Close()
+    n2 := newNotifier(t, config.WebhookConfig{URL: srv2.URL, Events: []string{utils.EventKnockStep}})
+    n2.Notify(utils.AuditEvent{Event: utils.EventGrant})
+    n2.Notify(utils.AuditEvent{Event: utils.EventKnockStep})
+    rec2.wait(t, 1)
+    time.Sleep(50 * time.Millisecond)
+    if c := rec2.count(); c != 1 {
+        t.Errorf("收到 %d 个请求，期望 1 个", c)
+    }
+}
+
+func TestWebhookRetryBackoff(t *testing.T) {
+    rec, srv := newRecorder(2)
+    defer srv.Close()
+
+    n := newNotifier(t, config.WebhookConfig{URL: srv.URL, Retries: 3, BackoffMs: 20})
+    n.Notify(utils.AuditEvent{Event: utils.EventGrant})
+    rec.wait(t, 3)
+
+    // 第三次成功后不再重试
+    time.Sleep(100 * time.Millisecond)
+    if c := rec.count(); c != 3 {
+        t.Fatalf("收到 %d 个请求，期望 3 个", c)
+    }
+    // 两次重试之间的等待依次为 20ms、40ms
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    for i, min := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+        if d := rec.times[i+1].Sub(rec.times[i]); d < min {
+            t.Errorf("第 %d 次重试只等待了 %v，期望至少 %v", i+1, d, min)
+        }
+    }
+}
+
+func TestWebhookGiveUp(t *testing.T) {
+    rec, srv := newRecorder(100)
+    defer srv.Close()
+
+    n := newNotifier(t, config.WebhookConfig{URL: srv.URL, Retries: 1, BackoffMs: 10})
+    n.Notify(utils.AuditEvent{Event: utils.EventGrant})
+    rec.wait(t, 2)
+    time.Sleep(100 * time.Millisecond)
+    if c := rec.count(); c != 2 {
+        t.Errorf("收到 %d 个请求，期望 2 个（首次 + 1 次重试）", c)
+    }
+}
+
+func TestWebhookQueueOverflow(t *testing.T) {
+    // 目标一直阻塞，发送协程卡在第一个事件上，队列填满后多出的事件被丢弃
+    release := make(chan struct{})
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        <-release
+    }))
+    defer srv.Close()
+    defer close(release)
+
+    n := newNotifier(t, config.WebhookConfig{URL: srv.URL})
+    h := n.hooks[0]
+    n.Notify(utils.AuditEvent{Event: utils.EventGrant})
+    deadline := time.Now().Add(5 * time.Second)
+    for len(h.queue) != 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("发送协程没有取走第一个事件")
+        }
+        time.Sleep(time.Millisecond)
+    }
+
+    for i := 0; i < queueSize+10; i++ {
+        n.Notify(utils.AuditEvent{Event: utils.EventGrant})
+    }
+    if len(h.queue) != queueSize {
+        t.Errorf("队列长度 %d，期望 %d", len(h.queue), queueSize)
+    }
+    if h.dropped != 10 {
+        t.Errorf("丢弃 %d 条，期望 10 条", h.dropped)
+    }
+}
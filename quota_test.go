@@ -0,0 +1,32 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "portknock/config"
+)
+
+// 配置重载不能重置每个来源当天的授权次数
+func TestRestoreGrantsKeepsDailyQuota(t *testing.T) {
+    now := time.Now()
+    cfg := &config.ServiceConfig{Name: "ssh", AllowPort: 22, MaxGrantsPerSourcePerDay: 2}
+    old := &KnockServer{cfg: cfg, stateMap: make(map[string]*KnockState), dailyGrants: make(map[string]int), closed: make(chan struct{})}
+    old.checkQuotaLocked("192.0.2.1", &KnockState{}, now)
+    old.dailyGrants["192.0.2.1"] = 2
+    old.dailyGrants["192.0.2.2"] = 1
+
+    s := &KnockServer{cfg: cfg, stateMap: make(map[string]*KnockState), dailyGrants: make(map[string]int), closed: make(chan struct{})}
+    s.RestoreGrants(old.Close())
+
+    if denied := s.checkQuotaLocked("192.0.2.1", &KnockState{}, now); denied == "" {
+        t.Error("重载后已用完配额的来源应被拒绝")
+    }
+    if denied := s.checkQuotaLocked("192.0.2.2", &KnockState{}, now); denied != "" {
+        t.Errorf("重载后未用完配额的来源不应被拒绝: %s", denied)
+    }
+    // 跨天后计数照常清零
+    if denied := s.checkQuotaLocked("192.0.2.1", &KnockState{}, now.AddDate(0, 0, 1)); denied != "" {
+        t.Errorf("次日不应再被拒绝: %s", denied)
+    }
+}
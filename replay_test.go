@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+
+    "portknock/spa"
+)
+
+// 客户端时钟偏快时，令牌在发送后很久仍可通过校验；在令牌失效前的最后一刻重放也必须被识别
+func TestReplayAtWindowEdge(t *testing.T) {
+    s := &KnockServer{spaNonces: make(map[string]time.Time)}
+    secret := []byte("s3cret")
+    sent := time.Unix(1700000010, 0) // 时间片起点
+    // 客户端时钟快了 DefaultMaxSkew：令牌发出时，服务器还在上一个时间片
+    first := sent.Add(-spa.DefaultMaxSkew)
+
+    seq, window := spa.SYNToken("ssh", 22, secret, sent)
+    slot, ok := spa.MatchSYN("ssh", 22, secret, seq, window, true, first, spa.DefaultMaxSkew)
+    if !ok {
+        t.Fatal("covert SYN 令牌应通过校验")
+    }
+    nonce, expiry := fmt.Sprintf("syn/alice/%d", slot), spa.SlotExpiry(slot, spa.DefaultMaxSkew)
+    if !s.useNonceLocked(nonce, expiry, first) {
+        t.Fatal("首次使用令牌应成功")
+    }
+    edge := expiry.Add(-time.Second)
+    if _, ok := spa.MatchSYN("ssh", 22, secret, seq, window, true, edge, spa.DefaultMaxSkew); !ok {
+        t.Fatal("窗口边缘令牌应仍然有效")
+    }
+    if s.useNonceLocked(nonce, expiry, edge) {
+        t.Error("窗口边缘重放 covert SYN 令牌应被拒绝")
+    }
+
+    key := []byte("12345678901234567890")
+    code := spa.TOTP(key, sent)
+    step, ok := spa.MatchTOTP(key, code, first, httpTOTPSkew)
+    if !ok {
+        t.Fatal("TOTP 动态码应通过校验")
+    }
+    nonce, expiry = fmt.Sprintf("totp/alice/%d", step), spa.TOTPExpiry(step, httpTOTPSkew)
+    if !s.useNonceLocked(nonce, expiry, first) {
+        t.Fatal("首次使用动态码应成功")
+    }
+    edge = expiry.Add(-time.Second)
+    if _, ok := spa.MatchTOTP(key, code, edge, httpTOTPSkew); !ok {
+        t.Fatal("窗口边缘动态码应仍然有效")
+    }
+    if s.useNonceLocked(nonce, expiry, edge) {
+        t.Error("窗口边缘重放 TOTP 动态码应被拒绝")
+    }
+
+    // 失效之后记录被清理
+    if !s.useNonceLocked("other", expiry.Add(time.Minute), expiry.Add(time.Second)) || len(s.spaNonces) != 1 {
+        t.Errorf("过期记录未被清理: %v", s.spaNonces)
+    }
+}
@@ -0,0 +1,61 @@
+package spa
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base32"
+    "encoding/binary"
+    "strings"
+    "time"
+)
+
+// DNS 敲门：查询名的第一个标签携带令牌
+//   base32(nonce(5) | HMAC-SHA256(密钥, 服务名 + 0x00 + "dns" + 时间片序号(8) + nonce) 的前 10 字节)
+// 共 24 个字符，时间片与 covert SYN 相同。DNS 名称不区分大小写，校验时统一转换。
+const (
+    dnsNonceSize = 5
+    dnsMACSize   = 10
+    dnsLabelLen  = 24
+)
+
+var dnsEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// DNSLabel 生成一个携带令牌的 DNS 标签
+func DNSLabel(service string, secret []byte, now time.Time) (string, error) {
+    nonce := make([]byte, dnsNonceSize)
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    token := append(nonce, dnsMAC(service, secret, slotOf(now), nonce)...)
+    return strings.ToLower(dnsEncoding.EncodeToString(token)), nil
+}
+
+// MatchDNSLabel 校验标签是否由 secret 在 now 前后 maxSkew 内生成，返回其中的 nonce 和匹配的时间片序号，
+// 调用方可据此防止重放（nonce 至少保留到 SlotExpiry）
+func MatchDNSLabel(label, service string, secret []byte, now time.Time, maxSkew time.Duration) ([]byte, int64, bool) {
+    if len(label) != dnsLabelLen {
+        return nil, 0, false
+    }
+    token, err := dnsEncoding.DecodeString(strings.ToUpper(label))
+    if err != nil || len(token) != dnsNonceSize+dnsMACSize {
+        return nil, 0, false
+    }
+    nonce, mac := token[:dnsNonceSize], token[dnsNonceSize:]
+    for n := slotOf(now.Add(-maxSkew)); n <= slotOf(now.Add(maxSkew)); n++ {
+        if hmac.Equal(mac, dnsMAC(service, secret, n, nonce)) {
+            return nonce, n, true
+        }
+    }
+    return nil, 0, false
+}
+
+func dnsMAC(service string, secret []byte, slot int64, nonce []byte) []byte {
+    h := hmac.New(sha256.New, secret)
+    h.Write([]byte(service))
+    h.Write([]byte{0})
+    h.Write([]byte("dns"))
+    h.Write(binary.BigEndian.AppendUint64(nil, uint64(slot)))
+    h.Write(nonce)
+    return h.Sum(nil)[:dnsMACSize]
+}
@@ -0,0 +1,75 @@
+package spa
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMatchDNSLabel(t *testing.T) {
+    secret := []byte("s3cret")
+    now := time.Unix(1700000010, 0)
+    label, err := DNSLabel("ssh", secret, now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(label) != dnsLabelLen || label != strings.ToLower(label) {
+        t.Fatalf("标签 %q 应为 %d 个小写字符", label, dnsLabelLen)
+    }
+
+    nonce, slot, ok := MatchDNSLabel(label, "ssh", secret, now, time.Minute)
+    if !ok || len(nonce) != dnsNonceSize || slot != slotOf(now) {
+        t.Fatalf("MatchDNSLabel(%q) = %x, %d, %v", label, nonce, slot, ok)
+    }
+
+    // DNS 名称不区分大小写，递归解析器可能改写大小写（如 0x20 编码）
+    mixed := []byte(label)
+    for i := range mixed {
+        if i%2 == 0 {
+            mixed[i] = byte(strings.ToUpper(string(mixed[i]))[0])
+        }
+    }
+    for _, l := range []string{strings.ToUpper(label), string(mixed)} {
+        got, _, ok := MatchDNSLabel(l, "ssh", secret, now, time.Minute)
+        if !ok || !bytes.Equal(got, nonce) {
+            t.Errorf("MatchDNSLabel(%q) = %x, %v，期望 %x, true", l, got, ok, nonce)
+        }
+    }
+
+    tests := []struct {
+        name    string
+        label   string
+        service string
+        secret  []byte
+        now     time.Time
+    }{
+        {"超出容差", label, "ssh", secret, now.Add(3 * time.Minute)},
+        {"其他服务名", label, "web", secret, now},
+        {"其他密钥", label, "ssh", []byte("other"), now},
+        {"长度不符", label[1:], "ssh", secret, now},
+        {"非 base32 字符", "0" + label[1:], "ssh", secret, now},
+    }
+    for _, tt := range tests {
+        if _, _, ok := MatchDNSLabel(tt.label, tt.service, tt.secret, tt.now, time.Minute); ok {
+            t.Errorf("%s: 不应通过", tt.name)
+        }
+    }
+}
+
+func TestDNSLabelExpiry(t *testing.T) {
+    // 调用方按 SlotExpiry 保留 nonce：到期前一刻标签仍然有效，到期后不再被接受
+    secret := []byte("s3cret")
+    sent := time.Unix(1700000010, 0)
+    label, err := DNSLabel("ssh", secret, sent)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expiry := SlotExpiry(slotOf(sent), DefaultMaxSkew)
+    if _, _, ok := MatchDNSLabel(label, "ssh", secret, expiry.Add(-time.Second), DefaultMaxSkew); !ok {
+        t.Errorf("SlotExpiry 之前 1 秒标签应仍然有效")
+    }
+    if _, _, ok := MatchDNSLabel(label, "ssh", secret, expiry, DefaultMaxSkew); ok {
+        t.Errorf("SlotExpiry 时标签不应再被接受")
+    }
+}
@@ -0,0 +1,103 @@
+// Package spa 实现单包授权（Single Packet Authorization）数据包的生成与校验
+package spa
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// 数据包格式：
+//   magic(3) | version(1) | unix 时间戳(8) | nonce(16) | 客户端名长度(1) | 客户端名 | HMAC-SHA256(32)
+// HMAC 的输入为 服务名 + 0x00 + HMAC 之前的全部字节，因此同一个包不能用于其他服务。
+const (
+    magic     = "PK1"
+    version   = 1
+    nonceSize = 16
+    macSize   = sha256.Size
+    headerLen = len(magic) + 1 + 8 + nonceSize + 1
+)
+
+// DefaultMaxSkew 默认允许的客户端与服务端时间偏差
+const DefaultMaxSkew = 30 * time.Second
+
+var (
+    ErrMalformed     = errors.New("SPA 数据包格式错误")
+    ErrUnknownClient = errors.New("未知的 SPA 客户端")
+    ErrBadMAC        = errors.New("SPA 签名校验失败")
+    ErrExpired       = errors.New("SPA 数据包时间戳超出允许范围")
+)
+
+// Packet 是校验通过的 SPA 数据包内容
+type Packet struct {
+    Client    string
+    Timestamp time.Time
+    Nonce     []byte
+}
+
+// Build 生成一个 SPA 数据包
+func Build(service, client string, secret []byte, now time.Time) ([]byte, error) {
+    if len(client) == 0 || len(client) > 255 {
+        return nil, fmt.Errorf("客户端名长度必须在 1-255 之间")
+    }
+
+    buf := make([]byte, 0, headerLen+len(client)+macSize)
+    buf = append(buf, magic...)
+    buf = append(buf, version)
+    buf = binary.BigEndian.AppendUint64(buf, uint64(now.Unix()))
+
+    nonce := make([]byte, nonceSize)
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    buf = append(buf, nonce...)
+    buf = append(buf, byte(len(client)))
+    buf = append(buf, client...)
+
+    return append(buf, sign(secret, service, buf)...), nil
+}
+
+// Verify 校验 SPA 数据包；lookup 根据客户端名返回其密钥
+func Verify(data []byte, service string, lookup func(client string) ([]byte, bool), now time.Time, maxSkew time.Duration) (*Packet, error) {
+    if len(data) < headerLen+1+macSize || !bytes.HasPrefix(data, []byte(magic)) || data[len(magic)] != version {
+        return nil, ErrMalformed
+    }
+
+    nameLen := int(data[headerLen-1])
+    if len(data) != headerLen+nameLen+macSize {
+        return nil, ErrMalformed
+    }
+    body := data[:headerLen+nameLen]
+    mac := data[headerLen+nameLen:]
+    client := string(body[headerLen:])
+
+    secret, ok := lookup(client)
+    if !ok {
+        return nil, ErrUnknownClient
+    }
+    if !hmac.Equal(mac, sign(secret, service, body)) {
+        return nil, ErrBadMAC
+    }
+
+    ts := time.Unix(int64(binary.BigEndian.Uint64(data[len(magic)+1:])), 0)
+    if skew := now.Sub(ts); skew > maxSkew || skew < -maxSkew {
+        return nil, ErrExpired
+    }
+
+    nonce := make([]byte, nonceSize)
+    copy(nonce, data[len(magic)+1+8:])
+    return &Packet{Client: client, Timestamp: ts, Nonce: nonce}, nil
+}
+
+func sign(secret []byte, service string, body []byte) []byte {
+    h := hmac.New(sha256.New, secret)
+    h.Write([]byte(service))
+    h.Write([]byte{0})
+    h.Write(body)
+    return h.Sum(nil)
+}
@@ -0,0 +1,54 @@
+package spa
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/binary"
+    "time"
+)
+
+// covert SYN 敲门：令牌藏在发往放行端口的普通 TCP SYN 中。
+// HMAC-SHA256(密钥, 服务名 + 0x00 + "syn" + 端口(2) + 时间片序号(8)) 的前 4 字节作为初始序列号，
+// 随后 2 字节（最高位置 1，使其落在常见的窗口大小范围内）作为窗口值。
+
+// tokenSlot 是 covert SYN 和 DNS 敲门令牌的时间片长度
+const tokenSlot = 30 * time.Second
+
+// slotOf 返回 t 所在时间片的序号
+func slotOf(t time.Time) int64 {
+    return t.Unix() / int64(tokenSlot/time.Second)
+}
+
+// SlotExpiry 返回时间片 slot 的令牌在容差 maxSkew 下不再被接受的时间，
+// 调用方应至少保留已使用的令牌到此时，防止重放
+func SlotExpiry(slot int64, maxSkew time.Duration) time.Time {
+    return time.Unix((slot+1)*int64(tokenSlot/time.Second), 0).Add(maxSkew)
+}
+
+// SYNToken 返回 now 所在时间片内发往 port 的 covert SYN 应使用的初始序列号和窗口值
+func SYNToken(service string, port uint16, secret []byte, now time.Time) (seq uint32, window uint16) {
+    return synToken(service, port, secret, slotOf(now))
+}
+
+// MatchSYN 判断 seq（checkWindow 时还有 window）是否由 secret 在 now 前后 maxSkew 内生成，
+// 返回匹配的时间片序号，调用方可据此防止重放
+func MatchSYN(service string, port uint16, secret []byte, seq uint32, window uint16, checkWindow bool, now time.Time, maxSkew time.Duration) (int64, bool) {
+    for n := slotOf(now.Add(-maxSkew)); n <= slotOf(now.Add(maxSkew)); n++ {
+        s, w := synToken(service, port, secret, n)
+        if s == seq && (!checkWindow || w == window) {
+            return n, true
+        }
+    }
+    return 0, false
+}
+
+func synToken(service string, port uint16, secret []byte, slot int64) (uint32, uint16) {
+    h := hmac.New(sha256.New, secret)
+    h.Write([]byte(service))
+    h.Write([]byte{0})
+    h.Write([]byte("syn"))
+    h.Write(binary.BigEndian.AppendUint16(nil, port))
+    h.Write(binary.BigEndian.AppendUint64(nil, uint64(slot)))
+    sum := h.Sum(nil)
+    return binary.BigEndian.Uint32(sum), binary.BigEndian.Uint16(sum[4:]) | 0x8000
+}
@@ -0,0 +1,66 @@
+package spa
+
+import (
+    "testing"
+    "time"
+)
+
+func TestMatchSYNSkew(t *testing.T) {
+    secret := []byte("s3cret")
+    sent := time.Unix(1700000010, 0) // 时间片起点
+    seq, window := SYNToken("ssh", 22, secret, sent)
+    if window&0x8000 == 0 {
+        t.Errorf("窗口值 %#x 的最高位应为 1", window)
+    }
+    slot := slotOf(sent)
+
+    tests := []struct {
+        name        string
+        offset      time.Duration
+        checkWindow bool
+        window      uint16
+        ok          bool
+    }{
+        {"同一时间片", 10 * time.Second, true, window, true},
+        {"晚到一个时间片内", 30*time.Second + 20*time.Second, true, window, true},
+        {"早到（服务器时钟偏慢）", -20 * time.Second, true, window, true},
+        {"超出容差", 2*time.Minute + 30*time.Second, true, window, false},
+        {"窗口值不符", 0, true, window ^ 1, false},
+        {"不检查窗口值", 0, false, window ^ 1, true},
+    }
+    for _, tt := range tests {
+        got, ok := MatchSYN("ssh", 22, secret, seq, tt.window, tt.checkWindow, sent.Add(tt.offset), time.Minute)
+        if ok != tt.ok || (ok && got != slot) {
+            t.Errorf("%s: MatchSYN = %d, %v，期望 %d, %v", tt.name, got, ok, slot, tt.ok)
+        }
+    }
+
+    // 令牌与服务名、端口和密钥绑定
+    now := sent.Add(time.Second)
+    if _, ok := MatchSYN("web", 22, secret, seq, window, true, now, time.Minute); ok {
+        t.Error("其他服务名不应通过")
+    }
+    if _, ok := MatchSYN("ssh", 2222, secret, seq, window, true, now, time.Minute); ok {
+        t.Error("其他端口不应通过")
+    }
+    if _, ok := MatchSYN("ssh", 22, []byte("other"), seq, window, true, now, time.Minute); ok {
+        t.Error("其他密钥不应通过")
+    }
+}
+
+func TestSlotExpiry(t *testing.T) {
+    // 令牌在 SlotExpiry 之前一直有效（客户端时钟偏快时可能远晚于发送时间），之后不再被接受
+    secret := []byte("s3cret")
+    sent := time.Unix(1700000010, 0)
+    seq, window := SYNToken("ssh", 22, secret, sent)
+    expiry := SlotExpiry(slotOf(sent), DefaultMaxSkew)
+    if want := sent.Add(tokenSlot + DefaultMaxSkew); !expiry.Equal(want) {
+        t.Fatalf("SlotExpiry = %v，期望 %v", expiry, want)
+    }
+    if _, ok := MatchSYN("ssh", 22, secret, seq, window, true, expiry.Add(-time.Second), DefaultMaxSkew); !ok {
+        t.Errorf("SlotExpiry 之前 1 秒令牌应仍然有效")
+    }
+    if _, ok := MatchSYN("ssh", 22, secret, seq, window, true, expiry, DefaultMaxSkew); ok {
+        t.Errorf("SlotExpiry 时令牌不应再被接受")
+    }
+}
@@ -0,0 +1,50 @@
+package spa
+
+import (
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/subtle"
+    "encoding/binary"
+    "fmt"
+    "time"
+)
+
+// TOTP 动态码（RFC 6238）：HMAC-SHA1、30 秒步长、6 位数字，与常见身份验证器应用一致
+const (
+    totpStep   = 30 * time.Second
+    totpDigits = 6
+)
+
+// TOTP 返回 t 所在步长的动态码
+func TOTP(key []byte, t time.Time) string {
+    return totpCode(key, t.Unix()/int64(totpStep/time.Second))
+}
+
+// MatchTOTP 校验 code 是否为 now 前后 skew 个步长内的动态码，返回匹配的步长序号，调用方可据此防止重放
+func MatchTOTP(key []byte, code string, now time.Time, skew int) (int64, bool) {
+    if len(code) != totpDigits {
+        return 0, false
+    }
+    cur := now.Unix() / int64(totpStep/time.Second)
+    for n := cur - int64(skew); n <= cur+int64(skew); n++ {
+        if subtle.ConstantTimeCompare([]byte(totpCode(key, n)), []byte(code)) == 1 {
+            return n, true
+        }
+    }
+    return 0, false
+}
+
+// TOTPExpiry 返回步长序号为 step 的动态码在容差 skew 下不再被接受的时间，
+// 调用方应至少保留已使用的动态码到此时，防止重放
+func TOTPExpiry(step int64, skew int) time.Time {
+    return time.Unix((step+1+int64(skew))*int64(totpStep/time.Second), 0)
+}
+
+func totpCode(key []byte, counter int64) string {
+    h := hmac.New(sha1.New, key)
+    h.Write(binary.BigEndian.AppendUint64(nil, uint64(counter)))
+    sum := h.Sum(nil)
+    off := sum[len(sum)-1] & 0x0f
+    v := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+    return fmt.Sprintf("%06d", v%1000000)
+}
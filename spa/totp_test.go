@@ -0,0 +1,68 @@
+package spa
+
+import (
+    "testing"
+    "time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量（取 8 位结果的后 6 位）
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestTOTPVectors(t *testing.T) {
+    tests := []struct {
+        unix int64
+        code string
+    }{
+        {59, "287082"},
+        {1111111109, "081804"},
+        {1111111111, "050471"},
+        {1234567890, "005924"},
+        {2000000000, "279037"},
+        {20000000000, "353130"},
+    }
+    for _, tt := range tests {
+        if got := TOTP(rfc6238Key, time.Unix(tt.unix, 0)); got != tt.code {
+            t.Errorf("TOTP(%d) = %s，期望 %s", tt.unix, got, tt.code)
+        }
+    }
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+    now := time.Unix(1234567890, 0)
+    code := TOTP(rfc6238Key, now)
+    cur := now.Unix() / 30
+
+    // 前后各一个步长内有效，并返回生成动态码的步长序号
+    for _, d := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+        step, ok := MatchTOTP(rfc6238Key, code, now.Add(d), 1)
+        if !ok || step != cur {
+            t.Errorf("偏移 %v: MatchTOTP = %d, %v，期望 %d, true", d, step, ok, cur)
+        }
+    }
+    for _, d := range []time.Duration{-60 * time.Second, 60 * time.Second} {
+        if _, ok := MatchTOTP(rfc6238Key, code, now.Add(d), 1); ok {
+            t.Errorf("偏移 %v: 超出容差的动态码不应通过", d)
+        }
+    }
+    for _, bad := range []string{"", "00592", "0059240", "005925"} {
+        if _, ok := MatchTOTP(rfc6238Key, bad, now, 1); ok {
+            t.Errorf("MatchTOTP(%q) 不应通过", bad)
+        }
+    }
+}
+
+func TestTOTPExpiry(t *testing.T) {
+    now := time.Unix(1234567890, 0)
+    code := TOTP(rfc6238Key, now)
+    step, ok := MatchTOTP(rfc6238Key, code, now, 1)
+    if !ok {
+        t.Fatal("动态码应通过校验")
+    }
+    expiry := TOTPExpiry(step, 1)
+    if _, ok := MatchTOTP(rfc6238Key, code, expiry.Add(-time.Second), 1); !ok {
+        t.Errorf("TOTPExpiry 之前 1 秒动态码应仍然有效")
+    }
+    if _, ok := MatchTOTP(rfc6238Key, code, expiry, 1); ok {
+        t.Errorf("TOTPExpiry 时动态码不应再被接受")
+    }
+}
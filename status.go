@@ -0,0 +1,193 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "portknock/config"
+    "portknock/utils"
+)
+
+// DefaultStatusPath 是默认的状态文件路径
+const DefaultStatusPath = "/run/portknock/status.json"
+
+// statusInterval 定期刷新状态文件的间隔，status 子命令据此判断状态是否过期
+const statusInterval = 10 * time.Second
+
+// daemonStatus 是写入状态文件的运行状态
+type daemonStatus struct {
+    Version   string           `json:"version"`
+    PID       int              `json:"pid"`
+    Started   time.Time        `json:"started"`
+    Updated   time.Time        `json:"updated"`
+    Listeners []ListenerStatus `json:"listeners"`
+    Services  []serviceStatus  `json:"services"`
+}
+
+type serviceStatus struct {
+    Name         string   `json:"name"`
+    Interfaces   []string `json:"interfaces"`
+    ActiveGrants int      `json:"active_grants"`
+}
+
+// statusPath 返回配置中的状态文件路径
+func statusPath(cfg *config.Config) string {
+    if cfg != nil && cfg.Status.Path != "" {
+        return cfg.Status.Path
+    }
+    return DefaultStatusPath
+}
+
+// ActiveGrants 返回当前仍在有效期内的授权数量
+func (s *KnockServer) ActiveGrants() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    n := 0
+    now := time.Now()
+    for _, state := range s.stateMap {
+        if state.Granted && state.AllowedUntil.After(now) {
+            n++
+        }
+    }
+    return n
+}
+
+// writeStatus 把各抓包监听和服务的状态写入状态文件
+func (d *daemon) writeStatus() {
+    d.smu.Lock()
+    defer d.smu.Unlock()
+
+    st := daemonStatus{
+        Version: Version,
+        PID:     os.Getpid(),
+        Started: d.started,
+        Updated: time.Now(),
+    }
+    d.lmu.Lock()
+    for _, l := range d.listeners {
+        st.Listeners = append(st.Listeners, l.snapshot())
+    }
+    d.lmu.Unlock()
+    sort.Slice(st.Listeners, func(i, j int) bool { return st.Listeners[i].Interface < st.Listeners[j].Interface })
+
+    for _, s := range d.servers {
+        st.Services = append(st.Services, serviceStatus{
+            Name:         s.cfg.Name,
+            Interfaces:   s.cfg.InterfacePatterns(),
+            ActiveGrants: s.ActiveGrants(),
+        })
+    }
+
+    data, err := json.MarshalIndent(st, "", "  ")
+    if err != nil {
+        return
+    }
+    path := statusPath(d.cfg)
+    if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+        utils.LogDebug("写入状态文件 %s 失败: %v", path, err)
+    }
+}
+
+// statusLoop 定期刷新状态文件，直到 d.stop 关闭
+func (d *daemon) statusLoop() {
+    defer d.wg.Done()
+
+    ticker := time.NewTicker(statusInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            d.writeStatus()
+        case <-d.stop:
+            return
+        }
+    }
+}
+
+// writeFileAtomic 先写临时文件再改名，读取方不会看到写了一半的内容
+func writeFileAtomic(path string, data []byte) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// runStatusCommand 实现 `portknock status [--config path] [--json]` 子命令，
+// 所有抓包都在运行时返回 0
+func runStatusCommand(args []string) int {
+    fs := flag.NewFlagSet("status", flag.ContinueOnError)
+    configPath := fs.String("config", utils.DefaultConfigPath, "配置文件路径（用于确定状态文件位置）")
+    path := fs.String("status-file", "", "状态文件路径，默认取自配置文件")
+    asJSON := fs.Bool("json", false, "输出原始 JSON")
+    if err := fs.Parse(args); err != nil {
+        return 2
+    }
+
+    if *path == "" {
+        // 配置读取失败时使用默认路径
+        cfg, _ := config.LoadConfig(*configPath)
+        *path = statusPath(cfg)
+    }
+
+    data, err := ioutil.ReadFile(*path)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ 无法读取状态文件 %s: %v（服务是否在运行？）\n", *path, err)
+        return 1
+    }
+    if *asJSON {
+        os.Stdout.Write(data)
+    }
+    var st daemonStatus
+    if err := json.Unmarshal(data, &st); err != nil {
+        fmt.Fprintf(os.Stderr, "❌ 状态文件 %s 格式错误: %v\n", *path, err)
+        return 1
+    }
+
+    healthy := true
+    if age := time.Since(st.Updated); age > 3*statusInterval {
+        healthy = false
+        if !*asJSON {
+            fmt.Printf("⚠️  状态已 %v 未更新，服务可能已停止\n", age.Round(time.Second))
+        }
+    }
+    for _, l := range st.Listeners {
+        if l.State != listenerRunning {
+            healthy = false
+        }
+    }
+    if *asJSON {
+        if healthy {
+            return 0
+        }
+        return 1
+    }
+
+    fmt.Printf("portknock %s (pid %d)，启动于 %s\n", st.Version, st.PID, st.Started.Format("2006-01-02 15:04:05"))
+    fmt.Println("抓包监听:")
+    for _, l := range st.Listeners {
+        fmt.Printf("  %-12s %-9s 自 %s，重启 %d 次，服务 %v\n",
+            l.Interface, l.State, l.Since.Format("15:04:05"), l.Restarts, l.Services)
+        if l.LastError != "" {
+            fmt.Printf("  %-12s 最近错误: %s\n", "", l.LastError)
+        }
+    }
+    fmt.Println("服务:")
+    for _, s := range st.Services {
+        fmt.Printf("  %-16s 网卡 %v，有效授权 %d 个\n", s.Name, s.Interfaces, s.ActiveGrants)
+    }
+
+    if !healthy {
+        return 1
+    }
+    return 0
+}
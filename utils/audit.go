@@ -0,0 +1,118 @@
+// utils/audit.go
+
+package utils
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// 审计事件类型
+const (
+    EventKnockStep          = "knock_step"
+    EventKnockReset         = "knock_reset"
+    EventGrant              = "grant"
+    EventGrantDenied        = "grant_denied"
+    EventRevoke             = "revoke"
+    EventBan                = "ban" // 来源被暂时拒绝：用完每日授权配额，或 HTTP 敲门凭据错误次数过多
+    EventDirectAccessDenied = "direct_access_denied"
+    EventConfigReload       = "config_reload"
+)
+
+// AuditEvent 审计事件，以 JSON 行的形式写入审计日志
+type AuditEvent struct {
+    Time    time.Time `json:"timestamp"`
+    Event   string    `json:"event"`
+    Service string    `json:"service,omitempty"`
+    SrcIP   string    `json:"src_ip,omitempty"`
+    Port    int       `json:"port,omitempty"`
+    Reason  string    `json:"reason,omitempty"`
+    Country string    `json:"country,omitempty"` // 来源所属国家，配置了 GeoIP 数据库时填写
+}
+
+// AuditSink 审计事件的订阅者（如 Webhook 通知），不应阻塞
+type AuditSink func(AuditEvent)
+
+var (
+    auditSinks []AuditSink
+    auditMu   sync.Mutex
+    auditFile *os.File
+    auditPath string
+)
+
+// InitAudit 打开审计日志文件，path 为空时不输出审计事件
+func InitAudit(path string) error {
+    auditMu.Lock()
+    defer auditMu.Unlock()
+
+    if auditFile != nil {
+        auditFile.Close()
+        auditFile = nil
+    }
+    auditPath = path
+    if path == "" {
+        return nil
+    }
+    return openAuditFile()
+}
+
+// ReopenAudit 重新打开审计日志文件（配合外部 logrotate）
+func ReopenAudit() error {
+    auditMu.Lock()
+    defer auditMu.Unlock()
+
+    if auditFile == nil {
+        return nil
+    }
+    auditFile.Close()
+    auditFile = nil
+    return openAuditFile()
+}
+
+// openAuditFile 以追加方式打开审计日志，调用方需持有 auditMu
+func openAuditFile() error {
+    path := auditPath
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return fmt.Errorf("无法创建审计日志目录: %v", err)
+    }
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+    if err != nil {
+        return fmt.Errorf("无法打开审计日志文件: %v", err)
+    }
+    auditFile = file
+    return nil
+}
+
+// SetAuditSinks 设置审计事件的订阅者（替换之前的全部订阅者）
+func SetAuditSinks(sinks ...AuditSink) {
+    auditMu.Lock()
+    auditSinks = sinks
+    auditMu.Unlock()
+}
+
+// Audit 写入一条审计事件（与普通日志级别无关），并分发给订阅者
+func Audit(ev AuditEvent) {
+    if ev.Time.IsZero() {
+        ev.Time = time.Now()
+    }
+
+    auditMu.Lock()
+    sinks := auditSinks
+    if auditFile != nil {
+        data, err := json.Marshal(ev)
+        if err != nil {
+            LogError("序列化审计事件失败: %v", err)
+        } else if _, err := auditFile.Write(append(data, '\n')); err != nil {
+            LogError("写入审计日志失败: %v", err)
+        }
+    }
+    auditMu.Unlock()
+
+    for _, sink := range sinks {
+        sink(ev)
+    }
+}
@@ -35,43 +35,40 @@ services:
 `
 
 // EnsureConfigFileExists 检查配置文件是否存在，若无则创建示例配置
-func EnsureConfigFileExists() error {
-    dir := filepath.Dir(DefaultConfigPath)
+func EnsureConfigFileExists(path string) error {
+    dir := filepath.Dir(path)
     if err := os.MkdirAll(dir, 0755); err != nil {
         return err
     }
 
-    if _, err := os.Stat(DefaultConfigPath); os.IsNotExist(err) {
-        LogWarn("未找到配置文件，正在创建示例配置: %s\n", DefaultConfigPath)
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        LogWarn("未找到配置文件，正在创建示例配置: %s\n", path)
 
-        err := ioutil.WriteFile(DefaultConfigPath, []byte(commentedExampleConfig), 0644)
+        err := ioutil.WriteFile(path, []byte(commentedExampleConfig), 0644)
         if err != nil {
             return err
         }
 
-        LogWarn("示例配置已写入（默认禁用），请编辑 %s 并取消注释服务配置后重新运行程序", DefaultConfigPath)
+        LogWarn("示例配置已写入（默认禁用），请编辑 %s 并取消注释服务配置后重新运行程序", path)
         return fmt.Errorf("示例配置已生成但被注释，请编辑后再运行")
     }
     return nil
 }
 
-// LoadAndValidateConfig 加载并验证配置文件是否有效
-func LoadAndValidateConfig() (*config.Config, error) {
-    data, err := ioutil.ReadFile(DefaultConfigPath)
+// LoadAndValidateConfig 加载配置文件并做语义检查，逐条记录发现的问题
+func LoadAndValidateConfig(path string) (*config.Config, error) {
+    cfg, err := config.LoadConfig(path)
     if err != nil {
-        return nil, err
-    }
-
-    var cfg config.Config
-    if err := cfg.ParseYAML(data); err != nil {
         LogError("解析配置文件失败: %v", err)
         return nil, err
     }
 
-    if len(cfg.Services) == 0 {
-        LogWarn("配置文件中 services 列表为空，请在 %s 中添加服务配置", DefaultConfigPath)
-        return nil, fmt.Errorf("配置文件中 services 列表为空")
+    if problems := cfg.Validate(); len(problems) > 0 {
+        for _, p := range problems {
+            LogError("配置错误: %v", p)
+        }
+        return nil, fmt.Errorf("配置文件 %s 中有 %d 处错误", path, len(problems))
     }
 
-    return &cfg, nil
-}
\ No newline at end of file
+    return cfg, nil
+}
@@ -0,0 +1,64 @@
+// utils/journald.go
+
+package utils
+
+import (
+    "bytes"
+    "encoding/binary"
+    "net"
+    "strings"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalWriter 通过 journald 原生协议发送结构化日志
+type journalWriter struct {
+    conn *net.UnixConn
+}
+
+func newJournalWriter() (*journalWriter, error) {
+    conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+    if err != nil {
+        return nil, err
+    }
+    return &journalWriter{conn: conn}, nil
+}
+
+// journalPriority 将日志级别映射为 syslog 优先级
+func journalPriority(level LogLevel) string {
+    switch level {
+    case LogLevelDebug:
+        return "7"
+    case LogLevelInfo:
+        return "6"
+    case LogLevelWarn:
+        return "4"
+    default:
+        return "3"
+    }
+}
+
+func (j *journalWriter) Send(level LogLevel, caller, msg string) error {
+    var buf bytes.Buffer
+    writeJournalField(&buf, "PRIORITY", journalPriority(level))
+    writeJournalField(&buf, "SYSLOG_IDENTIFIER", "portknock")
+    writeJournalField(&buf, "CODE_FILE", caller)
+    writeJournalField(&buf, "MESSAGE", msg)
+    _, err := j.conn.Write(buf.Bytes())
+    return err
+}
+
+// writeJournalField 按 journald 协议写入字段，多行值使用长度前缀格式
+func writeJournalField(buf *bytes.Buffer, key, value string) {
+    if !strings.Contains(value, "\n") {
+        buf.WriteString(key + "=" + value + "\n")
+        return
+    }
+    buf.WriteString(key + "\n")
+    binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+    buf.WriteString(value + "\n")
+}
+
+func (j *journalWriter) Close() error {
+    return j.conn.Close()
+}
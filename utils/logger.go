@@ -3,18 +3,22 @@
 package utils
 
 import (
+    "encoding/json"
     "fmt"
     "io"
-    "log"
+    "log/syslog"
     "os"
-)
+    "path/filepath"
+    "runtime"
+    "strings"
+    "sync"
+    "time"
 
-var (
-    InfoLogger    *log.Logger
-    WarningLogger *log.Logger
-    ErrorLogger   *log.Logger
+    "portknock/config"
 )
 
+const DefaultLogFilePath = "/var/log/portknock/app.log"
+
 // LogLevel 控制日志输出级别
 type LogLevel int
 
@@ -25,65 +29,209 @@ const (
     LogLevelError
 )
 
-var currentLogLevel = LogLevelInfo // 默认日志级别
+func (l LogLevel) String() string {
+    switch l {
+    case LogLevelDebug:
+        return "DEBUG"
+    case LogLevelInfo:
+        return "INFO"
+    case LogLevelWarn:
+        return "WARN"
+    default:
+        return "ERROR"
+    }
+}
 
-func SetLogLevel(level LogLevel) {
-    currentLogLevel = level
+// ParseLogLevel 解析配置中的日志级别字符串
+func ParseLogLevel(s string) (LogLevel, error) {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "debug":
+        return LogLevelDebug, nil
+    case "", "info":
+        return LogLevelInfo, nil
+    case "warn", "warning":
+        return LogLevelWarn, nil
+    case "error":
+        return LogLevelError, nil
+    }
+    return LogLevelInfo, fmt.Errorf("未知的日志级别: %s", s)
 }
 
-func init() {
-    log.SetFlags(0) // 避免默认日志前缀干扰
+// logger 汇总所有日志输出目标
+type logger struct {
+    mu      sync.Mutex
+    level   LogLevel
+    json    bool
+    writers []io.Writer    // 已格式化整行输出的目标（文件、stdout）
+    file    *rotatingFile  // 日志文件（支持轮转和重新打开）
+    syslog  *syslog.Writer // syslog 目标
+    journal *journalWriter // journald 目标
 }
 
-// InitLogger 初始化日志系统（文件 + 控制台）
-func InitLogger(logFilePath string) error {
-    // 创建日志目录
-    logDir := "/var/log/portknock"
-    if err := os.MkdirAll(logDir, 0755); err != nil {
-        return fmt.Errorf("无法创建日志目录: %v", err)
-    }
+// 默认只输出到 stdout，InitLogger 之前的日志也不会丢失
+var std = &logger{level: LogLevelInfo, writers: []io.Writer{os.Stdout}}
 
-    // 打开日志文件
-    file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+func SetLogLevel(level LogLevel) {
+    std.mu.Lock()
+    std.level = level
+    std.mu.Unlock()
+}
+
+// InitLogger 按配置初始化日志系统（级别、格式、输出目标、轮转）
+func InitLogger(cfg config.LoggingConfig) error {
+    level, err := ParseLogLevel(cfg.Level)
     if err != nil {
-        return fmt.Errorf("无法打开日志文件: %v", err)
+        return err
     }
 
-    // 多写入器：同时输出到控制台和文件
-    multiWriter := io.MultiWriter(os.Stdout, file)
+    format := strings.ToLower(cfg.Format)
+    if format != "" && format != "text" && format != "json" {
+        return fmt.Errorf("未知的日志格式: %s", cfg.Format)
+    }
 
-    // 设置日志前缀
-    InfoLogger = log.New(multiWriter, "[INFO] ", log.LstdFlags|log.Lshortfile)
-    WarningLogger = log.New(multiWriter, "[WARN] ", log.LstdFlags|log.Lshortfile)
-    ErrorLogger = log.New(multiWriter, "[ERROR] ", log.LstdFlags|log.Lshortfile)
+    targets := cfg.Targets
+    if len(targets) == 0 {
+        targets = []string{"file", "stdout"}
+    }
+
+    l := &logger{level: level, json: format == "json"}
+    for _, t := range targets {
+        switch strings.ToLower(t) {
+        case "file":
+            path := cfg.File
+            if path == "" {
+                path = DefaultLogFilePath
+            }
+            f, err := openRotatingFile(path, cfg.MaxSizeMB, cfg.MaxAgeDays, cfg.MaxBackups)
+            if err != nil {
+                return err
+            }
+            l.file = f
+            l.writers = append(l.writers, f)
+        case "stdout":
+            l.writers = append(l.writers, os.Stdout)
+        case "syslog":
+            w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "portknock")
+            if err != nil {
+                return fmt.Errorf("无法连接 syslog: %v", err)
+            }
+            l.syslog = w
+        case "journald":
+            w, err := newJournalWriter()
+            if err != nil {
+                return fmt.Errorf("无法连接 journald: %v", err)
+            }
+            l.journal = w
+        default:
+            return fmt.Errorf("未知的日志输出目标: %s", t)
+        }
+    }
 
+    std.mu.Lock()
+    old := &logger{file: std.file, syslog: std.syslog, journal: std.journal}
+    std.level, std.json, std.writers = l.level, l.json, l.writers
+    std.file, std.syslog, std.journal = l.file, l.syslog, l.journal
+    std.mu.Unlock()
+
+    old.close()
     return nil
 }
 
+// ReopenLogs 重新打开日志文件和审计日志（配合外部 logrotate，收到 SIGUSR1 时调用）
+func ReopenLogs() error {
+    std.mu.Lock()
+    var err error
+    if std.file != nil {
+        err = std.file.Reopen()
+    }
+    std.mu.Unlock()
+
+    if auditErr := ReopenAudit(); err == nil {
+        err = auditErr
+    }
+    return err
+}
+
+func (l *logger) close() {
+    if l.file != nil {
+        l.file.Close()
+    }
+    if l.syslog != nil {
+        l.syslog.Close()
+    }
+    if l.journal != nil {
+        l.journal.Close()
+    }
+}
+
+// output 格式化并写入一条日志，calldepth 用于定位调用者文件和行号
+func (l *logger) output(calldepth int, level LogLevel, msg string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if level < l.level {
+        return
+    }
+
+    now := time.Now()
+    caller := "???:0"
+    if _, file, lineNo, ok := runtime.Caller(calldepth); ok {
+        caller = fmt.Sprintf("%s:%d", filepath.Base(file), lineNo)
+    }
+    msg = strings.TrimRight(msg, "\n")
+
+    if len(l.writers) > 0 {
+        var line []byte
+        if l.json {
+            line, _ = json.Marshal(struct {
+                Time   time.Time `json:"time"`
+                Level  string    `json:"level"`
+                Caller string    `json:"caller"`
+                Msg    string    `json:"msg"`
+            }{now, strings.ToLower(level.String()), caller, msg})
+            line = append(line, '\n')
+        } else {
+            line = []byte(fmt.Sprintf("[%s] %s %s: %s\n", level, now.Format("2006/01/02 15:04:05"), caller, msg))
+        }
+        for _, w := range l.writers {
+            w.Write(line)
+        }
+    }
+
+    if l.syslog != nil {
+        switch level {
+        case LogLevelDebug:
+            l.syslog.Debug(msg)
+        case LogLevelInfo:
+            l.syslog.Info(msg)
+        case LogLevelWarn:
+            l.syslog.Warning(msg)
+        default:
+            l.syslog.Err(msg)
+        }
+    }
+
+    if l.journal != nil {
+        l.journal.Send(level, caller, msg)
+    }
+}
+
 // LogInfo 输出 INFO 日志
 func LogInfo(format string, v ...interface{}) {
-    if currentLogLevel <= LogLevelInfo {
-        InfoLogger.Output(2, fmt.Sprintf(format, v...))
-    }
+    std.output(2, LogLevelInfo, fmt.Sprintf(format, v...))
 }
 
 // LogWarn 输出 WARN 日志
 func LogWarn(format string, v ...interface{}) {
-    if currentLogLevel <= LogLevelWarn {
-        WarningLogger.Output(2, fmt.Sprintf(format, v...))
-    }
+    std.output(2, LogLevelWarn, fmt.Sprintf(format, v...))
 }
 
 // LogError 输出 ERROR 日志
 func LogError(format string, v ...interface{}) {
-    if currentLogLevel <= LogLevelError {
-        ErrorLogger.Output(2, fmt.Sprintf(format, v...))
-    }
+    std.output(2, LogLevelError, fmt.Sprintf(format, v...))
 }
 
 // LogDebug 输出 DEBUG 日志
 func LogDebug(format string, v ...interface{}) {
-    if currentLogLevel <= LogLevelDebug {
-        InfoLogger.Output(2, fmt.Sprintf("[DEBUG] "+format, v...))
-    }
-}
\ No newline at end of file
+    std.output(2, LogLevelDebug, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,137 @@
+// utils/rotate.go
+
+package utils
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+// rotatingFile 按大小 / 时间轮转的日志文件
+type rotatingFile struct {
+    mu         sync.Mutex
+    path       string
+    maxSize    int64         // 0 表示不按大小轮转
+    maxAge     time.Duration // 0 表示不按时间轮转
+    maxBackups int           // 0 表示保留全部历史文件
+    file       *os.File
+    size       int64
+    openedAt   time.Time
+}
+
+func openRotatingFile(path string, maxSizeMB, maxAgeDays, maxBackups int) (*rotatingFile, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, fmt.Errorf("无法创建日志目录: %v", err)
+    }
+    r := &rotatingFile{
+        path:       path,
+        maxSize:    int64(maxSizeMB) * 1024 * 1024,
+        maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+        maxBackups: maxBackups,
+    }
+    if err := r.open(); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+func (r *rotatingFile) open() error {
+    file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return fmt.Errorf("无法打开日志文件: %v", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return err
+    }
+    r.file = file
+    r.size = info.Size()
+    r.openedAt = time.Now()
+    return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.file == nil {
+        return 0, os.ErrClosed
+    }
+    if r.needRotate(len(p)) {
+        if err := r.rotate(); err != nil {
+            fmt.Fprintf(os.Stderr, "日志轮转失败: %v\n", err)
+        }
+    }
+    n, err := r.file.Write(p)
+    r.size += int64(n)
+    return n, err
+}
+
+func (r *rotatingFile) needRotate(n int) bool {
+    if r.maxSize > 0 && r.size > 0 && r.size+int64(n) > r.maxSize {
+        return true
+    }
+    return r.maxAge > 0 && time.Since(r.openedAt) > r.maxAge
+}
+
+// rotate 将当前文件重命名为带时间戳的历史文件，并清理多余的历史文件
+func (r *rotatingFile) rotate() error {
+    r.file.Close()
+    r.file = nil
+
+    // 时间戳精确到毫秒，同一毫秒内多次轮转时追加序号，避免覆盖之前的历史文件
+    stamp := time.Now().Format("20060102-150405.000")
+    backup := fmt.Sprintf("%s.%s", r.path, stamp)
+    for i := 1; ; i++ {
+        if _, err := os.Lstat(backup); os.IsNotExist(err) {
+            break
+        }
+        backup = fmt.Sprintf("%s.%s-%d", r.path, stamp, i)
+    }
+    if err := os.Rename(r.path, backup); err != nil && !os.IsNotExist(err) {
+        r.open()
+        return err
+    }
+    if err := r.open(); err != nil {
+        return err
+    }
+
+    if r.maxBackups > 0 {
+        backups, _ := filepath.Glob(r.path + ".*")
+        sort.Strings(backups)
+        for len(backups) > r.maxBackups {
+            os.Remove(backups[0])
+            backups = backups[1:]
+        }
+    }
+    return nil
+}
+
+// Reopen 关闭并重新打开日志文件（文件已被外部工具移走时使用）
+func (r *rotatingFile) Reopen() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.file != nil {
+        r.file.Close()
+        r.file = nil
+    }
+    return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.file == nil {
+        return nil
+    }
+    err := r.file.Close()
+    r.file = nil
+    return err
+}